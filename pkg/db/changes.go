@@ -0,0 +1,93 @@
+package db
+
+// Changes describes a set of mutations to tables performed during a
+// transaction.
+type Changes []Change
+
+// Change describes a mutation to an object in a table.
+type Change struct {
+	Table  string
+	Before interface{}
+	After  interface{}
+
+	// primaryKey stores the raw key value from the id index so that multiple
+	// updates of the same object in the same transaction can be merged
+	primaryKey []byte
+}
+
+// Created returns true if the mutation describes a new object being inserted.
+func (m *Change) Created() bool {
+	return m.Before == nil && m.After != nil
+}
+
+// Updated returns true if the mutation describes an existing object being
+// updated.
+func (m *Change) Updated() bool {
+	return m.Before != nil && m.After != nil
+}
+
+// Deleted returns true if the mutation describes an existing object being
+// deleted.
+func (m *Change) Deleted() bool {
+	return m.Before != nil && m.After == nil
+}
+
+// TrackChanges enables change tracking for the transaction. If called at any
+// point before commit, subsequent mutations will be recorded and can be
+// retrieved using Changes. This is a noop for read transactions.
+func (txn *Transaction) TrackChanges() {
+	if txn.writable && txn.changes == nil {
+		txn.changes = make(Changes, 0, 1)
+	}
+}
+
+// Changes returns the set of object changes that have been made in the
+// transaction since change tracking was enabled. It remains available after
+// Commit so the changes can be published. Multiple writes to the same object
+// are merged into a single change holding the object as it was before the
+// first write and after the last one, positioned at the last write. Objects
+// that were created and then deleted are left out.
+func (txn *Transaction) Changes() Changes {
+	if txn.changes == nil {
+		return nil
+	}
+
+	first := make(map[rowKey]int)
+	last := make(map[rowKey]int)
+	for i, m := range txn.changes {
+		key := rowKey{m.Table, string(m.primaryKey)}
+		if _, ok := first[key]; !ok {
+			first[key] = i
+		}
+		last[key] = i
+	}
+
+	cs := make(Changes, 0, len(last))
+	for i, m := range txn.changes {
+		key := rowKey{m.Table, string(m.primaryKey)}
+		if last[key] != i {
+			continue
+		}
+
+		m.Before = txn.changes[first[key]].Before
+		if m.Before == nil && m.After == nil {
+			continue
+		}
+		cs = append(cs, m)
+	}
+	return cs
+}
+
+// trackChange records a change if change tracking is enabled.
+func (txn *Transaction) trackChange(table string, primaryKey []byte, before, after interface{}) {
+	if txn.changes == nil {
+		return
+	}
+
+	txn.changes = append(txn.changes, Change{
+		Table:      table,
+		Before:     before,
+		After:      after,
+		primaryKey: primaryKey,
+	})
+}
@@ -28,10 +28,50 @@ func (s *InMemoryDBSchema) Validate() error {
 			return fmt.Errorf("table name mis-match for '%s'", name)
 		}
 
+		if name == indexTable {
+			return fmt.Errorf("table name '%s' is reserved", name)
+		}
+
 		if err := table.Validate(); err != nil {
 			return fmt.Errorf("table %q: %s", name, err)
 		}
 	}
 
+	// Foreign keys are checked once every table is known to be valid
+	for name, table := range s.Tables {
+		for i, fk := range table.ForeignKeys {
+			if err := fk.Validate(s, table); err != nil {
+				return fmt.Errorf("table %q: foreign key %d: %s", name, i, err)
+			}
+		}
+	}
+
 	return nil
 }
+
+// withIndexes returns a copy of the schema where the given table has the
+// given indexes. The schema is shared by transactions, so it is never modified
+// in place.
+func (s *InMemoryDBSchema) withIndexes(table string, indexes map[string]*IndexSchema) *InMemoryDBSchema {
+	tables := make(map[string]*TableSchema, len(s.Tables))
+	for name, tableSchema := range s.Tables {
+		tables[name] = tableSchema
+	}
+	tableSchema := *s.Tables[table]
+	tableSchema.Indexes = indexes
+	tables[table] = &tableSchema
+	return &InMemoryDBSchema{Tables: tables}
+}
+
+// references returns the foreign keys referencing the given table.
+func (s *InMemoryDBSchema) references(table string) []reference {
+	var refs []reference
+	for name, tableSchema := range s.Tables {
+		for _, fk := range tableSchema.ForeignKeys {
+			if fk.RefTable == table {
+				refs = append(refs, reference{Table: name, ForeignKey: fk})
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,83 @@
+package db
+
+// FilterFunc is a predicate over the results of an iterator. It returns
+// whether the result should be kept.
+type FilterFunc func(interface{}) bool
+
+// FilterIterator is used to wrap a ResultIterator and only return the results
+// matching a predicate.
+type FilterIterator struct {
+	filter FilterFunc
+	iter   ResultIterator
+}
+
+// NewFilterIterator returns a FilterIterator over iter keeping the results
+// for which filter returns true.
+func NewFilterIterator(iter ResultIterator, filter FilterFunc) *FilterIterator {
+	return &FilterIterator{
+		filter: filter,
+		iter:   iter,
+	}
+}
+
+// Next returns the next result of the wrapped iterator matching the filter
+func (f *FilterIterator) Next() interface{} {
+	for {
+		value := f.iter.Next()
+		if value == nil || f.filter(value) {
+			return value
+		}
+	}
+}
+
+// LimitIterator is used to wrap a ResultIterator and stop after a maximum
+// number of results.
+type LimitIterator struct {
+	limit int
+	iter  ResultIterator
+}
+
+// NewLimitIterator returns a LimitIterator returning at most limit results
+// of iter.
+func NewLimitIterator(iter ResultIterator, limit int) *LimitIterator {
+	return &LimitIterator{
+		limit: limit,
+		iter:  iter,
+	}
+}
+
+// Next returns the next result of the wrapped iterator, or nil once the
+// limit is reached
+func (l *LimitIterator) Next() interface{} {
+	if l.limit <= 0 {
+		return nil
+	}
+	l.limit--
+	return l.iter.Next()
+}
+
+// OffsetIterator is used to wrap a ResultIterator and skip its first results.
+type OffsetIterator struct {
+	offset int
+	iter   ResultIterator
+}
+
+// NewOffsetIterator returns an OffsetIterator skipping the first offset
+// results of iter. The results are skipped on the first call to Next.
+func NewOffsetIterator(iter ResultIterator, offset int) *OffsetIterator {
+	return &OffsetIterator{
+		offset: offset,
+		iter:   iter,
+	}
+}
+
+// Next returns the next result of the wrapped iterator past the offset
+func (o *OffsetIterator) Next() interface{} {
+	for ; o.offset > 0; o.offset-- {
+		if o.iter.Next() == nil {
+			o.offset = 0
+			return nil
+		}
+	}
+	return o.iter.Next()
+}
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"fmt"
+	"github.com/pawarchetan/zendesk-db/pkg/index"
+	"reflect"
+)
+
+// ReferentialAction defines what happens to the rows referencing a row that
+// is deleted.
+type ReferentialAction int
+
+const (
+	// Restrict rejects deleting a row that is still referenced
+	Restrict ReferentialAction = iota
+
+	// Cascade deletes the referencing rows along with the row
+	Cascade
+
+	// SetNull resets the referencing field of the referencing rows
+	SetNull
+)
+
+// ForeignKey declares that the values of an index of a table are keys of rows
+// in another table.
+// Index: Index is the index of the referencing table holding the keys. Objects
+// missing a value for it reference nothing. It must encode its keys like
+// RefIndex, so it has the same indexer type, or is a string and string slice
+// index pair, and cannot be a compound index.
+// RefTable: RefTable is the referenced table.
+// RefIndex: RefIndex is the unique index of the referenced table the keys are
+// looked up in.
+// OnDelete: OnDelete is the action taken on the referencing rows when the row
+// they reference is deleted.
+// Field: Field is the struct field reset to its zero value by SetNull. The
+// index must be AllowMissing and report the zero value as missing, as the
+// string indexes do for an empty string. The int, uint and bool indexes store
+// the zero value, so they cannot be set null.
+//
+// Inserting a row whose keys do not exist in the referenced table fails, and
+// so does an update removing a key that is still referenced.
+type ForeignKey struct {
+	Index    string
+	RefTable string
+	RefIndex string
+	OnDelete ReferentialAction
+	Field    string
+}
+
+// ForeignKeyError is returned when a write would leave a row referencing a
+// key that does not exist.
+type ForeignKeyError struct {
+	Table    string
+	Index    string
+	RefTable string
+	Value    []byte
+}
+
+func (e *ForeignKeyError) Error() string {
+	return fmt.Sprintf("foreign key violation on index '%s' of table '%s' referencing table '%s'", e.Index, e.Table, e.RefTable)
+}
+
+// reference is a foreign key along with the table it is declared on
+type reference struct {
+	Table string
+	*ForeignKey
+}
+
+// Validate is used to validate the foreign key of a table against the schema
+// of the database.
+func (fk *ForeignKey) Validate(schema *InMemoryDBSchema, table *TableSchema) error {
+	if fk == nil {
+		return fmt.Errorf("foreign key is nil")
+	}
+
+	indexSchema, ok := table.Indexes[fk.Index]
+	if !ok {
+		return fmt.Errorf("invalid index '%s'", fk.Index)
+	}
+
+	refTable, ok := schema.Tables[fk.RefTable]
+	if !ok {
+		return fmt.Errorf("invalid referenced table '%s'", fk.RefTable)
+	}
+	refIndex, ok := refTable.Indexes[fk.RefIndex]
+	if !ok {
+		return fmt.Errorf("invalid referenced index '%s'", fk.RefIndex)
+	}
+	if !refIndex.isUnique() {
+		return fmt.Errorf("referenced index '%s' must be unique", fk.RefIndex)
+	}
+	if _, ok := refIndex.Indexer.(index.SingleIndexer); !ok {
+		return fmt.Errorf("referenced index '%s' must be a SingleIndexer", fk.RefIndex)
+	}
+
+	// The keys are compared as they are stored, so both indexes must encode
+	// their values the same way
+	if _, ok := indexSchema.Indexer.(*index.CompoundIndex); ok {
+		return fmt.Errorf("index '%s' cannot be a compound index", fk.Index)
+	}
+	if keyEncoding(indexSchema.Indexer) != keyEncoding(refIndex.Indexer) {
+		return fmt.Errorf("index '%s' does not encode its keys like referenced index '%s'", fk.Index, fk.RefIndex)
+	}
+
+	switch fk.OnDelete {
+	case Restrict, Cascade:
+	case SetNull:
+		if fk.Field == "" {
+			return fmt.Errorf("missing field to set null for index '%s'", fk.Index)
+		}
+		if !indexSchema.AllowMissing {
+			return fmt.Errorf("index '%s' must allow missing values to be set null", fk.Index)
+		}
+		if !clearsToMissing(indexSchema.Indexer, fk.Field) {
+			return fmt.Errorf("index '%s' does not report field '%s' as missing once set null", fk.Index, fk.Field)
+		}
+	default:
+		return fmt.Errorf("invalid delete action %d", fk.OnDelete)
+	}
+	return nil
+}
+
+// keyEncoding describes how an indexer encodes its values. The string and
+// string slice indexes share their encoding, other indexers only match
+// indexers of the same type.
+func keyEncoding(indexer index.Indexer) string {
+	switch i := indexer.(type) {
+	case *index.StringFieldIndex:
+		return fmt.Sprintf("string lowercase=%t", i.Lowercase)
+	case *index.StringSliceFieldIndex:
+		return fmt.Sprintf("string lowercase=%t", i.Lowercase)
+	default:
+		return reflect.TypeOf(indexer).String()
+	}
+}
+
+// clearsToMissing reports whether an object is missing from an index once the
+// given field is reset to its zero value. Custom indexers are trusted to
+// report it as missing.
+func clearsToMissing(indexer index.Indexer, field string) bool {
+	switch i := indexer.(type) {
+	case *index.StringFieldIndex:
+		return i.Field == field
+	case *index.StringSliceFieldIndex:
+		return i.Field == field
+	case *index.IntFieldIndex, *index.UintFieldIndex, *index.BoolFieldIndex:
+		return false
+	case *index.CompoundIndex:
+		// A compound key is missing as soon as one of its parts is
+		for _, sub := range i.Indexes {
+			if clearsToMissing(sub, field) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// checkReferences checks that every key referenced by obj exists.
+func (txn *Transaction) checkReferences(table string, obj interface{}) error {
+	for _, fk := range txn.schema.Tables[table].ForeignKeys {
+		ok, keys, err := indexValues(txn.schema.Tables[table].Indexes[fk.Index], obj, nil)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", fk.Index, err)
+		}
+		if !ok {
+			continue
+		}
+
+		refTxn := txn.read(fk.RefTable, fk.RefIndex)
+		for _, key := range keys {
+			if _, found := refTxn.Get(key); !found {
+				return &ForeignKeyError{Table: table, Index: fk.Index, RefTable: fk.RefTable, Value: key}
+			}
+		}
+	}
+	return nil
+}
+
+// checkReferencedUpdate checks that updating existing to obj does not remove
+// a key that is still referenced.
+func (txn *Transaction) checkReferencedUpdate(table string, existing, obj interface{}) error {
+	for _, ref := range txn.schema.references(table) {
+		refIndex := txn.schema.Tables[table].Indexes[ref.RefIndex]
+		okExist, keysExist, err := indexValues(refIndex, existing, nil)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", ref.RefIndex, err)
+		}
+		if !okExist {
+			continue
+		}
+		ok, keys, err := indexValues(refIndex, obj, nil)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", ref.RefIndex, err)
+		}
+		if ok && containsValue(keys, keysExist[0]) {
+			continue
+		}
+
+		if len(txn.referencing(ref, keysExist[0])) > 0 {
+			return &ForeignKeyError{Table: ref.Table, Index: ref.Index, RefTable: table, Value: keysExist[0]}
+		}
+	}
+	return nil
+}
+
+// onDelete applies the referential actions to the rows referencing a row
+// deleted from the table.
+func (txn *Transaction) onDelete(table string, obj interface{}) error {
+	for _, ref := range txn.schema.references(table) {
+		refIndex := txn.schema.Tables[table].Indexes[ref.RefIndex]
+		ok, keys, err := indexValues(refIndex, obj, nil)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", ref.RefIndex, err)
+		}
+		if !ok {
+			continue
+		}
+
+		rows := txn.referencing(ref, keys[0])
+		if len(rows) == 0 {
+			continue
+		}
+
+		switch ref.OnDelete {
+		case Restrict:
+			return &ForeignKeyError{Table: ref.Table, Index: ref.Index, RefTable: table, Value: keys[0]}
+
+		case Cascade:
+			for _, row := range rows {
+				// The row may already be deleted by an earlier cascade
+				if err := txn.delete(ref.Table, row); err != nil && err != ErrNotFound {
+					return err
+				}
+			}
+
+		case SetNull:
+			for _, row := range rows {
+				cleared, err := clearField(row, ref.Field)
+				if err != nil {
+					return err
+				}
+				if err := txn.insert(ref.Table, cleared); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// referencing returns the rows referencing the given key through ref.
+func (txn *Transaction) referencing(ref reference, key []byte) []interface{} {
+	indexIter := txn.read(ref.Table, ref.Index).Root().Iterator()
+	indexIter.SeekPrefix(key)
+	iter := &radixIterator{iter: indexIter}
+
+	var rows []interface{}
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		rows = append(rows, obj)
+	}
+	return rows
+}
+
+// clearField returns a copy of obj with the given field reset to its zero
+// value. Stored objects are shared with other transactions, so they are never
+// modified in place.
+func clearField(obj interface{}, field string) (interface{}, error) {
+	v := reflect.ValueOf(obj)
+	isPtr := v.Kind() == reflect.Ptr
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot set field '%s' of %#v", field, obj)
+	}
+
+	copied := reflect.New(v.Type())
+	copied.Elem().Set(v)
+
+	fv := copied.Elem().FieldByName(field)
+	if !fv.IsValid() || !fv.CanSet() {
+		return nil, fmt.Errorf("field '%s' for %#v is invalid", field, obj)
+	}
+	fv.Set(reflect.Zero(fv.Type()))
+
+	if isPtr {
+		return copied.Interface(), nil
+	}
+	return copied.Elem().Interface(), nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"github.com/pawarchetan/zendesk-db/pkg/index"
+	"testing"
+)
+
+type node struct {
+	ID     string
+	Kind   string
+	Parent string
+}
+
+func TestDeleteAll_CascadeSelfReference(t *testing.T) {
+	schema := &InMemoryDBSchema{
+		Tables: map[string]*TableSchema{
+			"nodes": {
+				Name: "nodes",
+				Indexes: map[string]*IndexSchema{
+					"_id":    {Name: "_id", Indexer: &index.StringFieldIndex{Field: "ID"}},
+					"kind":   {Name: "kind", Indexer: &index.StringFieldIndex{Field: "Kind"}},
+					"parent": {Name: "parent", AllowMissing: true, Indexer: &index.StringFieldIndex{Field: "Parent"}},
+				},
+				ForeignKeys: []*ForeignKey{
+					{Index: "parent", RefTable: "nodes", RefIndex: "_id", OnDelete: Cascade},
+				},
+			},
+		},
+	}
+	db, err := Init(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	defer txn.Abort()
+	for _, n := range []*node{
+		{ID: "a", Kind: "x"},
+		{ID: "b", Kind: "x", Parent: "a"},
+		{ID: "c", Kind: "x", Parent: "b"},
+		{ID: "d", Kind: "y"},
+	} {
+		if err := txn.Insert("nodes", n); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	num, err := txn.DeleteAll("nodes", "kind", "x")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if num != 3 {
+		t.Fatalf("expected 3 rows deleted, got %d", num)
+	}
+
+	count, err := txn.Count("nodes", id)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 row left, got %d", count)
+	}
+	if _, err := txn.First("nodes", id, "d"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
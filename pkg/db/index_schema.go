@@ -8,9 +8,39 @@ import (
 // IndexSchema is the schema for an index. An index defines how a table is queried.
 // Name of the index. This must be unique among a tables set of indexes.
 // This must match the key in the map of Indexes for a TableSchema.
+// AllowMissing allows objects without a value for the index to be inserted,
+// they are simply left out of the index.
+// Unique rejects inserting an object whose index value is already used by
+// another object. The id index is always unique.
 type IndexSchema struct {
-	Name    string
-	Indexer index.Indexer
+	Name         string
+	AllowMissing bool
+	Unique       bool
+	Indexer      index.Indexer
+}
+
+// UniqueConstraintError is returned when an insert would store a value in a
+// unique index that is already used by another object.
+type UniqueConstraintError struct {
+	Table string
+	Index string
+	Value []byte
+}
+
+func (e *UniqueConstraintError) Error() string {
+	return fmt.Sprintf("unique constraint violation on index '%s' of table '%s'", e.Index, e.Table)
+}
+
+// supportsPrefix returns whether the index can be queried by prefix.
+func (s *IndexSchema) supportsPrefix() bool {
+	_, ok := s.Indexer.(index.PrefixIndexer)
+	return ok
+}
+
+// isUnique returns whether keys of the index are stored without the primary
+// ID suffix, which is the case for the id index and unique indexes.
+func (s *IndexSchema) isUnique() bool {
+	return s.Unique || s.Name == id
 }
 
 func (s *IndexSchema) Validate() error {
@@ -26,5 +56,24 @@ func (s *IndexSchema) Validate() error {
 	default:
 		return fmt.Errorf("index for '%s' must be a SingleIndexer or MultiIndexer", s.Name)
 	}
+
+	if compound, ok := s.Indexer.(*index.CompoundIndex); ok {
+		if len(compound.Indexes) == 0 {
+			return fmt.Errorf("compound index for '%s' has no sub-indexes", s.Name)
+		}
+		// Only the last sub-index may produce multiple values, otherwise the
+		// keys could not be combined
+		for i, sub := range compound.Indexes {
+			switch sub.(type) {
+			case index.SingleIndexer:
+			case index.MultiIndexer:
+				if i != len(compound.Indexes)-1 {
+					return fmt.Errorf("compound index for '%s' can only have a MultiIndexer as its last sub-index", s.Name)
+				}
+			default:
+				return fmt.Errorf("sub-index %d for '%s' must be a SingleIndexer or MultiIndexer", i, s.Name)
+			}
+		}
+	}
 	return nil
 }
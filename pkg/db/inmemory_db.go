@@ -1,18 +1,27 @@
 package db
 
 import (
+	"fmt"
 	"github.com/pawarchetan/zendesk-db/pkg/tree"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 )
 
 // InMemoryDB provides a table abstraction to store objects (rows) with multiple
 // indexes based on inserted values. The database makes use of radix
-// tree to manage transaction.
+// tree to manage transaction. The schema is stored in the root, so it can be
+// changed along with the indexes by AddIndex and DropIndex.
 type InMemoryDB struct {
-	schema  *InMemoryDBSchema
 	root    unsafe.Pointer
 	primary bool
+
+	// There can only be a single writer at once
+	writer sync.Mutex
+
+	// lastIndex is the index of the last commit that wrote any row. It only
+	// increases and is only modified while holding the writer lock.
+	lastIndex uint64
 }
 
 func Init(schema *InMemoryDBSchema) (*InMemoryDB, error) {
@@ -21,12 +30,11 @@ func Init(schema *InMemoryDBSchema) (*InMemoryDB, error) {
 	}
 
 	db := &InMemoryDB{
-		schema:  schema,
 		root:    unsafe.Pointer(tree.New()),
 		primary: true,
 	}
 
-	if err := db.initialize(); err != nil {
+	if err := db.initialize(schema); err != nil {
 		return nil, err
 	}
 
@@ -34,7 +42,7 @@ func Init(schema *InMemoryDBSchema) (*InMemoryDB, error) {
 }
 
 func (db *InMemoryDB) TableSchema() *InMemoryDBSchema {
-	return db.schema
+	return schemaOf(db.getRoot())
 }
 
 func (db *InMemoryDB) getRoot() *tree.Tree {
@@ -42,25 +50,190 @@ func (db *InMemoryDB) getRoot() *tree.Tree {
 	return root
 }
 
-// Transaction is used to start a new transaction in either read or write mode.
-func (db *InMemoryDB) Transaction() *Transaction {
+// Snapshot is used to capture a point-in-time snapshot of the database. As
+// the trees are immutable the snapshot shares them with the original, so it is
+// cheap to take. Writes to the snapshot do not affect the original and writes
+// to the original do not affect the snapshot. The stored objects themselves
+// are not copied. Writes to the snapshot do not fire the watches of the
+// original, but writes to the original may fire the watches of the snapshot.
+func (db *InMemoryDB) Snapshot() *InMemoryDB {
+	// The root is loaded before the commit index, as commits store them in
+	// the reverse order, so the snapshot never reuses an index of its root
+	clone := &InMemoryDB{
+		root:    unsafe.Pointer(db.getRoot()),
+		primary: false,
+	}
+	clone.lastIndex = atomic.LoadUint64(&db.lastIndex)
+	return clone
+}
+
+// Txn is used to start a new transaction in either read or write mode.
+// There can only be a single concurrent writer, but any number of readers.
+// A write transaction holds the writer lock until it is committed or aborted.
+func (db *InMemoryDB) Txn(write bool) *Transaction {
+	if write {
+		db.writer.Lock()
+	}
+	root := db.getRoot()
+	txn := &Transaction{
+		db:       db,
+		schema:   schemaOf(root),
+		writable: write,
+		rootTxn:  root.Transaction(),
+	}
+	return txn
+}
+
+// OptimisticTxn is used to start a new write transaction that does not take
+// the writer lock. Instead, the rows it reads and writes are recorded and
+// Commit returns ErrConflict if any of them were modified by another commit
+// since the transaction began. Otherwise its writes are applied on top of
+// the latest state of the database. Conflicting transactions can be retried.
+func (db *InMemoryDB) OptimisticTxn() *Transaction {
+	root := db.getRoot()
 	txn := &Transaction{
-		db:      db,
-		rootTxn: db.getRoot().Transaction(),
+		db:         db,
+		schema:     schemaOf(root),
+		writable:   true,
+		optimistic: true,
+		startRoot:  root,
+		rootTxn:    root.Transaction(),
 	}
 	return txn
 }
 
+// AddIndex adds an index to a table and fills it from the rows already
+// stored. The index is built in a write transaction, and becomes visible along
+// with the updated schema when it commits. Transactions started before keep
+// using the previous schema.
+func (db *InMemoryDB) AddIndex(table string, indexSchema *IndexSchema) error {
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	tableSchema, ok := txn.schema.Tables[table]
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+	if indexSchema == nil {
+		return fmt.Errorf("index schema is nil")
+	}
+	if _, ok := tableSchema.Indexes[indexSchema.Name]; ok {
+		return fmt.Errorf("index '%s' already exists on table '%s'", indexSchema.Name, table)
+	}
+
+	indexes := make(map[string]*IndexSchema, len(tableSchema.Indexes)+1)
+	for name, existing := range tableSchema.Indexes {
+		indexes[name] = existing
+	}
+	indexes[indexSchema.Name] = indexSchema
+	schema := txn.schema.withIndexes(table, indexes)
+	if err := schema.Validate(); err != nil {
+		return err
+	}
+
+	txn.schema = schema
+	txn.schemaChanges = append(txn.schemaChanges, tableIndex{table, indexSchema.Name})
+	txn.rootTxn.Insert([]byte(schemaKey), schema)
+	txn.rootTxn.Insert(indexPath(table, indexSchema.Name), tree.New())
+
+	// Backfill the index from the id index, with the same checks as Insert
+	indexTxn := txn.write(table, indexSchema.Name)
+	rows := &radixIterator{iter: txn.read(table, id).Root().Iterator()}
+	for obj := rows.Next(); obj != nil; obj = rows.Next() {
+		idVal, err := primaryKey(tableSchema, obj)
+		if err != nil {
+			return err
+		}
+
+		ok, values, err := indexValues(indexSchema, obj, idVal)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", indexSchema.Name, err)
+		}
+		if !ok {
+			if !indexSchema.AllowMissing {
+				return fmt.Errorf("missing value for index '%s'", indexSchema.Name)
+			}
+			continue
+		}
+
+		if err := txn.checkUnique(tableSchema, indexSchema, idVal, values); err != nil {
+			return err
+		}
+		for _, val := range values {
+			indexTxn.Insert(val, obj)
+		}
+	}
+
+	return txn.Commit()
+}
+
+// DropIndex removes an index from a table. The id index and the indexes used
+// by foreign keys cannot be dropped. The change becomes visible along with the
+// updated schema once its write transaction commits, and the watches on the
+// index fire then.
+func (db *InMemoryDB) DropIndex(table, name string) error {
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	tableSchema, ok := txn.schema.Tables[table]
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+	if _, ok := tableSchema.Indexes[name]; !ok {
+		return fmt.Errorf("invalid index '%s'", name)
+	}
+	if name == id {
+		return fmt.Errorf("cannot drop id index")
+	}
+
+	indexes := make(map[string]*IndexSchema, len(tableSchema.Indexes)-1)
+	for iName, existing := range tableSchema.Indexes {
+		if iName != name {
+			indexes[iName] = existing
+		}
+	}
+	schema := txn.schema.withIndexes(table, indexes)
+	if err := schema.Validate(); err != nil {
+		return err
+	}
+
+	// Empty the dropped index, only to close the channels of its watches
+	if db.primary {
+		raw, _ := txn.rootTxn.Get(indexPath(table, name))
+		dropped := raw.(*tree.Tree).Transaction()
+		dropped.TrackMutate(true)
+		dropped.DeletePrefix([]byte{})
+		txn.Defer(dropped.Notify)
+	}
+
+	txn.schema = schema
+	txn.schemaChanges = append(txn.schemaChanges, tableIndex{table, ""})
+	txn.rootTxn.Insert([]byte(schemaKey), schema)
+	txn.rootTxn.Delete(indexPath(table, name))
+	txn.rootTxn.Delete(lastIndexPath(table, name))
+	return txn.Commit()
+}
+
+// schemaOf returns the schema stored in a root.
+func schemaOf(root *tree.Tree) *InMemoryDBSchema {
+	raw, _ := root.Get([]byte(schemaKey))
+	return raw.(*InMemoryDBSchema)
+}
+
 // initialize is used to setup the DB for use after creation. This should
 // be called only once after allocating a InMemoryDB.
-func (db *InMemoryDB) initialize() error {
+func (db *InMemoryDB) initialize(schema *InMemoryDBSchema) error {
 	root := db.getRoot()
-	for tName, tableSchema := range db.schema.Tables {
+	root, _, _ = root.Insert([]byte(schemaKey), schema)
+	for tName, tableSchema := range schema.Tables {
 		for iName := range tableSchema.Indexes {
 			index := tree.New()
 			path := indexPath(tName, iName)
 			root, _, _ = root.Insert(path, index)
 		}
+
+		// The commit index of every row is kept next to the indexes
+		root, _, _ = root.Insert(indexPath(tName, rowIndex), tree.New())
 	}
 	db.root = unsafe.Pointer(root)
 	return nil
@@ -70,3 +243,12 @@ func (db *InMemoryDB) initialize() error {
 func indexPath(table, index string) []byte {
 	return []byte(table + "." + index)
 }
+
+// lastIndexPath returns the path from the root to the commit index that last
+// modified the given table, or one of its indexes if index is not empty.
+func lastIndexPath(table, index string) []byte {
+	if index == "" {
+		return indexPath(indexTable, table)
+	}
+	return indexPath(indexTable, table+"."+index)
+}
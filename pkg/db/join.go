@@ -0,0 +1,128 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Relation describes how rows of another table are joined to a row.
+// Name: Name is the key of the joined rows in JoinResult.Related.
+// Field: Field is the struct field of the row holding the lookup value.
+// Table, Index: Table and Index are where the value is looked up, as the
+// single argument of First, or of Get if Many is set.
+// Many: Many joins every matching row as a []interface{} rather than the
+// first one.
+type Relation struct {
+	Name  string
+	Field string
+	Table string
+	Index string
+	Many  bool
+}
+
+// JoinResult is a row along with the rows joined to it, by relation name. A
+// relation without a matching row is left out of Related. Err is set if a
+// relation could not be looked up, for example because the field is missing
+// or its value does not fit the index, and is nil otherwise.
+type JoinResult struct {
+	Object  interface{}
+	Related map[string]interface{}
+	Err     error
+}
+
+// Join is used to construct a ResultIterator returning a *JoinResult for every
+// row of iter, with the rows of other tables related to it. The related rows
+// are found through index lookups as the iterator advances. A row whose field
+// is a nil pointer, or cannot be found, has nothing joined for the relation.
+// Any other failure to look up a relation is reported in JoinResult.Err.
+func (txn *Transaction) Join(iter ResultIterator, relations ...Relation) (ResultIterator, error) {
+	for _, rel := range relations {
+		if rel.Name == "" {
+			return nil, fmt.Errorf("missing relation name")
+		}
+		if rel.Field == "" {
+			return nil, fmt.Errorf("missing field for relation '%s'", rel.Name)
+		}
+		if _, _, err := txn.getIndexValue(rel.Table, rel.Index); err != nil {
+			return nil, fmt.Errorf("relation '%s': %v", rel.Name, err)
+		}
+	}
+
+	return &joinIterator{
+		txn:       txn,
+		iter:      iter,
+		relations: relations,
+	}, nil
+}
+
+// joinIterator looks up the related rows of every row of iter
+type joinIterator struct {
+	txn       *Transaction
+	iter      ResultIterator
+	relations []Relation
+}
+
+func (j *joinIterator) Next() interface{} {
+	obj := j.iter.Next()
+	if obj == nil {
+		return nil
+	}
+
+	result := &JoinResult{
+		Object:  obj,
+		Related: make(map[string]interface{}, len(j.relations)),
+	}
+	for _, rel := range j.relations {
+		related, err := j.lookup(rel, obj)
+		if err != nil {
+			if result.Err == nil {
+				result.Err = fmt.Errorf("relation '%s': %v", rel.Name, err)
+			}
+			continue
+		}
+		if related != nil {
+			result.Related[rel.Name] = related
+		}
+	}
+	return result
+}
+
+// lookup returns the rows related to obj, or nil if there are none.
+func (j *joinIterator) lookup(rel Relation, obj interface{}) (interface{}, error) {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot read field '%s' of %#v", rel.Field, obj)
+	}
+	fv := v.FieldByName(rel.Field)
+	if !fv.IsValid() {
+		return nil, fmt.Errorf("field '%s' for %#v is invalid", rel.Field, obj)
+	}
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return nil, nil
+		}
+		fv = fv.Elem()
+	}
+	arg := fv.Interface()
+
+	if !rel.Many {
+		related, err := j.txn.First(rel.Table, rel.Index, arg)
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		return related, err
+	}
+
+	iter, err := j.txn.Get(rel.Table, rel.Index, arg)
+	if err != nil {
+		return nil, err
+	}
+	var rows []interface{}
+	for related := iter.Next(); related != nil; related = iter.Next() {
+		rows = append(rows, related)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return rows, nil
+}
@@ -0,0 +1,251 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Operator defines how the conditions of a Query are combined.
+type Operator int
+
+const (
+	// And matches the rows matching every condition
+	And Operator = iota
+
+	// Or matches the rows matching any condition
+	Or
+)
+
+func (op Operator) String() string {
+	switch op {
+	case And:
+		return "AND"
+	case Or:
+		return "OR"
+	default:
+		return fmt.Sprintf("Operator(%d)", int(op))
+	}
+}
+
+// Condition constrains a query to the rows matching the args on an index,
+// with the same semantics as Get. This includes prefix lookups through the
+// prefix suffix on the index name.
+type Condition struct {
+	Index string
+	Args  []interface{}
+}
+
+// Query is a set of conditions on a table, combined by an operator.
+type Query struct {
+	Table      string
+	Operator   Operator
+	Conditions []Condition
+}
+
+// condition is a Condition resolved against the schema
+type condition struct {
+	Condition
+	indexSchema *IndexSchema
+	val         []byte
+	rows        int
+}
+
+// matches reports whether obj is stored in the index under a key matching
+// the condition, without looking at the index itself.
+func (c *condition) matches(obj interface{}, idVal []byte) bool {
+	ok, keys, err := indexValues(c.indexSchema, obj, idVal)
+	if err != nil || !ok {
+		return false
+	}
+	for _, key := range keys {
+		if bytes.HasPrefix(key, c.val) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query is used to construct a ResultIterator over the rows matching the
+// conditions of q. With And, the rows of the most selective condition are
+// checked against the other conditions. With Or, the rows of every condition
+// are returned in turn. Each row is returned only once, even if it matches
+// several conditions or several values of a MultiIndexer.
+func (txn *Transaction) Query(q *Query) (ResultIterator, error) {
+	tableSchema, scans, filters, err := txn.plan(q)
+	if err != nil {
+		return nil, err
+	}
+
+	iter := &queryIterator{
+		tableSchema: tableSchema,
+		filters:     filters,
+		seen:        make(map[string]struct{}),
+	}
+	for _, cond := range scans {
+		iter.iters = append(iter.iters, txn.conditionIterator(q.Table, cond))
+	}
+	return txn.trackReads(q.Table, iter), nil
+}
+
+// Explain returns the plan Query would use to run q, without running it.
+func (txn *Transaction) Explain(q *Query) (*Plan, error) {
+	_, scans, filters, err := txn.plan(q)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Table:    q.Table,
+		Operator: q.Operator,
+	}
+	for _, cond := range scans {
+		plan.Scans = append(plan.Scans, cond.step())
+		plan.Rows += cond.rows
+	}
+	for _, cond := range filters {
+		plan.Filters = append(plan.Filters, cond.step())
+	}
+	return plan, nil
+}
+
+// plan validates q and splits its conditions into the ones to scan and the
+// ones to filter the scanned rows with. The number of rows matching each
+// condition is estimated from the leaf counts cached in the index trees. For
+// a MultiIndexer this counts index entries rather than rows, so it is an
+// upper bound.
+func (txn *Transaction) plan(q *Query) (*TableSchema, []*condition, []*condition, error) {
+	if q == nil || len(q.Conditions) == 0 {
+		return nil, nil, nil, fmt.Errorf("query has no conditions")
+	}
+	if q.Operator != And && q.Operator != Or {
+		return nil, nil, nil, fmt.Errorf("invalid query operator %d", q.Operator)
+	}
+
+	tableSchema, ok := txn.schema.Tables[q.Table]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("invalid table '%s'", q.Table)
+	}
+
+	conds := make([]*condition, 0, len(q.Conditions))
+	for _, c := range q.Conditions {
+		indexSchema, val, err := txn.getIndexValue(q.Table, c.Index, c.Args...)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		conds = append(conds, &condition{
+			Condition:   c,
+			indexSchema: indexSchema,
+			val:         val,
+			rows:        txn.read(q.Table, indexSchema.Name).Root().CountPrefix(val),
+		})
+	}
+
+	if q.Operator == Or {
+		return tableSchema, conds, nil, nil
+	}
+
+	// Drive with the most selective condition and check the rest from the
+	// most selective down, so rows are rejected as early as possible.
+	sort.SliceStable(conds, func(i, j int) bool {
+		return conds[i].rows < conds[j].rows
+	})
+	return tableSchema, conds[:1], conds[1:], nil
+}
+
+// conditionIterator returns an iterator over the rows matching cond.
+func (txn *Transaction) conditionIterator(table string, cond *condition) ResultIterator {
+	indexIter := txn.read(table, cond.indexSchema.Name).Root().Iterator()
+	indexIter.SeekPrefix(cond.val)
+	return &radixIterator{
+		iter: indexIter,
+	}
+}
+
+func (c *condition) step() PlanStep {
+	return PlanStep{
+		Index: c.Index,
+		Args:  c.Args,
+		Rows:  c.rows,
+	}
+}
+
+// Plan describes how a Query is run: the rows of every scan are returned in
+// turn, once each, if they match every filter.
+type Plan struct {
+	Table    string
+	Operator Operator
+	Scans    []PlanStep
+	Filters  []PlanStep
+
+	// Rows is the estimated number of rows scanned
+	Rows int
+}
+
+// PlanStep is a condition of a Plan along with the estimated number of rows
+// matching it.
+type PlanStep struct {
+	Index string
+	Args  []interface{}
+	Rows  int
+}
+
+func (p *Plan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s query on '%s' (~%d rows)", p.Operator, p.Table, p.Rows)
+	for _, step := range p.Scans {
+		fmt.Fprintf(&b, "\n  scan %s", step)
+	}
+	for _, step := range p.Filters {
+		fmt.Fprintf(&b, "\n  filter %s", step)
+	}
+	return b.String()
+}
+
+func (s PlanStep) String() string {
+	return fmt.Sprintf("'%s' %v (~%d rows)", s.Index, s.Args, s.Rows)
+}
+
+// queryIterator returns the rows of its iterators in turn, skipping the rows
+// already returned and the ones not matching every filter.
+type queryIterator struct {
+	tableSchema *TableSchema
+	iters       []ResultIterator
+	filters     []*condition
+	seen        map[string]struct{}
+}
+
+func (q *queryIterator) Next() interface{} {
+	for len(q.iters) > 0 {
+		obj := q.iters[0].Next()
+		if obj == nil {
+			q.iters = q.iters[1:]
+			continue
+		}
+
+		idVal, err := primaryKey(q.tableSchema, obj)
+		if err != nil {
+			continue
+		}
+		if _, ok := q.seen[string(idVal)]; ok {
+			continue
+		}
+		if !q.matches(obj, idVal) {
+			continue
+		}
+
+		q.seen[string(idVal)] = struct{}{}
+		return obj
+	}
+	return nil
+}
+
+func (q *queryIterator) matches(obj interface{}, idVal []byte) bool {
+	for _, cond := range q.filters {
+		if !cond.matches(obj, idVal) {
+			return false
+		}
+	}
+	return true
+}
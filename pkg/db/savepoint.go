@@ -0,0 +1,103 @@
+package db
+
+import (
+	"fmt"
+	"github.com/pawarchetan/zendesk-db/pkg/tree"
+)
+
+// Savepoint is a point within a write transaction that the transaction can be
+// rolled back to, undoing the writes made after it but keeping the ones made
+// before it.
+type Savepoint struct {
+	txn     *Transaction
+	seq     int
+	content map[tableIndex]*tree.Transaction
+	changes int
+	after   int
+	writes  int
+	ops     int
+}
+
+// Savepoint captures the current state of the transaction. As the index trees
+// are immutable, this only copies the roots of the indexes modified so far.
+func (txn *Transaction) Savepoint() *Savepoint {
+	txn.savepointSeq++
+	txn.savepoints = append(txn.savepoints, txn.savepointSeq)
+	sp := &Savepoint{
+		txn:     txn,
+		seq:     txn.savepointSeq,
+		content: make(map[tableIndex]*tree.Transaction, len(txn.content)),
+		changes: len(txn.changes),
+		after:   len(txn.after),
+		writes:  len(txn.writes),
+		ops:     len(txn.ops),
+	}
+	for key, subTxn := range txn.content {
+		sp.content[key] = subTxn.Clone()
+	}
+	return sp
+}
+
+// RollbackTo undoes every write made in the transaction since the savepoint
+// was taken. Changes recorded and functions deferred since then are discarded
+// as well. The savepoint, and any savepoint taken before it, remain valid
+// while the savepoints taken after it are invalidated for good.
+func (txn *Transaction) RollbackTo(sp *Savepoint) error {
+	if sp == nil || sp.txn != txn {
+		return fmt.Errorf("savepoint does not belong to this transaction")
+	}
+	pos := txn.savepointPos(sp)
+	if pos < 0 {
+		return fmt.Errorf("savepoint was invalidated by an earlier rollback")
+	}
+	if !txn.writable || txn.rootTxn == nil {
+		return fmt.Errorf("cannot rollback a finished or read-only transaction")
+	}
+
+	for key, subTxn := range txn.content {
+		if saved, ok := sp.content[key]; ok {
+			subTxn.Restore(saved)
+			continue
+		}
+
+		// The index was not modified before the savepoint
+		path := indexPath(key.Table, key.Index)
+		raw, _ := txn.rootTxn.Get(path)
+		subTxn.Restore(raw.(*tree.Tree).Transaction())
+	}
+
+	if txn.changes != nil && len(txn.changes) > sp.changes {
+		txn.changes = txn.changes[:sp.changes]
+	}
+	if len(txn.after) > sp.after {
+		txn.after = txn.after[:sp.after]
+	}
+	if len(txn.writes) > sp.writes {
+		txn.writes = txn.writes[:sp.writes]
+	}
+	if len(txn.ops) > sp.ops {
+		txn.ops = txn.ops[:sp.ops]
+	}
+	txn.savepoints = txn.savepoints[:pos+1]
+	return nil
+}
+
+// savepointPos returns the position of a savepoint among the valid ones, or
+// -1 if it is not valid.
+func (txn *Transaction) savepointPos(sp *Savepoint) int {
+	for i := len(txn.savepoints) - 1; i >= 0; i-- {
+		if txn.savepoints[i] == sp.seq {
+			return i
+		}
+	}
+	return -1
+}
+
+// releaseSavepoint invalidates a savepoint taken internally once it is no
+// longer needed, along with the savepoints taken after it, so they do not
+// pile up.
+func (txn *Transaction) releaseSavepoint(sp *Savepoint) {
+	if pos := txn.savepointPos(sp); pos >= 0 {
+		txn.savepoints = txn.savepoints[:pos]
+	}
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"github.com/pawarchetan/zendesk-db/pkg/index"
+	"testing"
+)
+
+func TestRollbackTo_InvalidatesLaterSavepoints(t *testing.T) {
+	schema := &InMemoryDBSchema{
+		Tables: map[string]*TableSchema{
+			"nodes": {
+				Name: "nodes",
+				Indexes: map[string]*IndexSchema{
+					"_id": {Name: "_id", Indexer: &index.StringFieldIndex{Field: "ID"}},
+				},
+			},
+		},
+	}
+	db, err := Init(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	spA := txn.Savepoint()
+	if err := txn.Insert("nodes", &node{ID: "1"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	spB := txn.Savepoint()
+	if err := txn.Insert("nodes", &node{ID: "2"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := txn.RollbackTo(spA); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A new savepoint must not revive the one taken after spA
+	txn.Savepoint()
+	if err := txn.RollbackTo(spB); err == nil {
+		t.Fatalf("expected rollback to an invalidated savepoint to fail")
+	}
+	if _, err := txn.First("nodes", id, "1"); err != ErrNotFound {
+		t.Fatalf("expected row 1 to stay rolled back, got %v", err)
+	}
+
+	// spA itself remains valid
+	if err := txn.RollbackTo(spA); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
@@ -9,9 +9,12 @@ import (
 // Name: Name of the table. This must match the key in the Tables map in InMemoryDBSchema.
 // Indexes: Indexes is the set of indexes for querying this table. The key
 // is a unique name for the index and must match the Name in the IndexSchema.
+// ForeignKeys: ForeignKeys is the set of references from this table to rows of
+// other tables. They are validated along with the InMemoryDBSchema.
 type TableSchema struct {
-	Name    string
-	Indexes map[string]*IndexSchema
+	Name        string
+	Indexes     map[string]*IndexSchema
+	ForeignKeys []*ForeignKey
 }
 
 // Validate is used to validate the table schema
@@ -37,6 +40,10 @@ func (s *TableSchema) Validate() error {
 			return fmt.Errorf("index name mis-match for '%s'", name)
 		}
 
+		if name == rowIndex {
+			return fmt.Errorf("index name '%s' is reserved", name)
+		}
+
 		if err := index.Validate(); err != nil {
 			return fmt.Errorf("index %q: %s", name, err)
 		}
@@ -1,15 +1,41 @@
 package db
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/pawarchetan/zendesk-db/pkg/index"
 	"github.com/pawarchetan/zendesk-db/pkg/tree"
+	"strings"
 	"sync/atomic"
 	"unsafe"
 )
 
 const (
 	id = "_id"
+
+	// rowIndex is the reserved index holding the commit index of every row
+	rowIndex = "_row_index"
+
+	// indexTable is the reserved table holding the commit index that last
+	// modified every table and index
+	indexTable = "index"
+
+	// prefixSuffix is appended to an index name to query it by prefix
+	prefixSuffix = "_prefix"
+
+	// schemaKey is the key of the schema in the root tree, so it changes
+	// atomically with the indexes. It never collides with an index path as
+	// it has no dot.
+	schemaKey = "schema"
+)
+
+var (
+	// ErrNotFound is returned when the requested item is not found
+	ErrNotFound = fmt.Errorf("not found")
+
+	// ErrConflict is returned when committing an optimistic transaction whose
+	// rows were modified by another commit since it began
+	ErrConflict = fmt.Errorf("transaction conflicts with a concurrent commit")
 )
 
 // tableIndex is a tuple of (Table, Index) used for lookups
@@ -18,11 +44,48 @@ type tableIndex struct {
 	Index string
 }
 
+// rowKey is a tuple of (Table, primary key) identifying a row
+type rowKey struct {
+	Table string
+	ID    string
+}
+
 // Transaction is a transaction against a InMemoryDB.
 type Transaction struct {
-	db      *InMemoryDB
-	rootTxn *tree.Transaction
-	content map[tableIndex]*tree.Transaction
+	db       *InMemoryDB
+	schema   *InMemoryDBSchema
+	writable bool
+	rootTxn  *tree.Transaction
+	content  map[tableIndex]*tree.Transaction
+
+	// changes is nil unless change tracking is enabled
+	changes Changes
+
+	// after is the list of functions to run once the transaction commits
+	after []func()
+
+	// schemaChanges lists the indexes added, or the tables whose indexes
+	// were dropped with an empty Index, which are recorded as modified on
+	// commit
+	schemaChanges []tableIndex
+
+	// savepointSeq numbers the savepoints, it only increases so a sequence
+	// number is never reused. savepoints holds the sequence numbers of the
+	// valid savepoints, in the order they were taken.
+	savepointSeq int
+	savepoints   []int
+
+	// writes is the list of rows written, their commit index is updated
+	// on commit
+	writes []rowKey
+
+	// optimistic transactions do not hold the writer lock. They record the
+	// rows read and the operations performed, so they can be checked for
+	// conflicts and replayed on top of the latest root on commit.
+	optimistic bool
+	startRoot  *tree.Tree
+	reads      map[rowKey]struct{}
+	ops        []func(*Transaction) error
 }
 
 func (txn *Transaction) read(table, index string) *tree.Transaction {
@@ -55,101 +118,587 @@ func (txn *Transaction) write(table, index string) *tree.Transaction {
 	raw, _ := txn.rootTxn.Get(path)
 	indexTxn := raw.(*tree.Tree).Transaction()
 
+	// Track the mutated nodes so watchers are notified on commit. Snapshots
+	// share their nodes with the original database, whose watchers must not
+	// be notified of their writes.
+	indexTxn.TrackMutate(txn.db.primary)
+
 	txn.content[key] = indexTxn
 	return indexTxn
 }
 
-// Abort is used to cancel this transaction.
+// Abort is used to cancel this transaction. This only discards the deferred
+// functions of read transactions, and is a noop for write transactions that
+// are already finished.
 func (txn *Transaction) Abort() {
+	if !txn.writable {
+		txn.after = nil
+		return
+	}
 	if txn.rootTxn == nil {
 		return
 	}
 
 	txn.rootTxn = nil
 	txn.content = nil
+	txn.changes = nil
+	txn.after = nil
+
+	if !txn.optimistic {
+		txn.db.writer.Unlock()
+	}
 }
 
-// Commit is used to finalize this transaction.
-func (txn *Transaction) Commit() {
+// Commit is used to finalize this transaction. This only runs the deferred
+// functions of read transactions, and is a noop for write transactions that
+// are already finished.
+// Committing an optimistic transaction returns ErrConflict if the rows it
+// read or wrote were modified since it began, in which case it is aborted.
+func (txn *Transaction) Commit() error {
+	if !txn.writable {
+		txn.runDeferred()
+		return nil
+	}
+	if txn.rootTxn == nil {
+		return nil
+	}
+
+	if txn.optimistic {
+		txn.db.writer.Lock()
+		if err := txn.rebase(); err != nil {
+			txn.rootTxn = nil
+			txn.content = nil
+			txn.changes = nil
+			txn.after = nil
+			txn.db.writer.Unlock()
+			return err
+		}
+	}
+
+	// Stamp the written rows with the index of this commit. The deleted rows
+	// are dropped instead, rebase sees them disappear as a conflict.
+	commitIndex := txn.db.lastIndex + 1
+	modified := false
+	for _, key := range txn.writes {
+		rowTxn := txn.write(key.Table, rowIndex)
+		if _, ok := txn.read(key.Table, id).Get([]byte(key.ID)); ok {
+			rowTxn.Insert([]byte(key.ID), commitIndex)
+		} else {
+			rowTxn.Delete([]byte(key.ID))
+		}
+		modified = true
+	}
+
+	// Record the index on every table and index that changed, through its
+	// rows or the schema
+	for key, subTxn := range txn.content {
+		if key.Index == rowIndex {
+			continue
+		}
+		raw, _ := txn.rootTxn.Get(indexPath(key.Table, key.Index))
+		if raw.(*tree.Tree).Root() == subTxn.Root() {
+			continue
+		}
+		txn.rootTxn.Insert(lastIndexPath(key.Table, ""), commitIndex)
+		txn.rootTxn.Insert(lastIndexPath(key.Table, key.Index), commitIndex)
+		modified = true
+	}
+	for _, key := range txn.schemaChanges {
+		txn.rootTxn.Insert(lastIndexPath(key.Table, ""), commitIndex)
+		if key.Index != "" {
+			txn.rootTxn.Insert(lastIndexPath(key.Table, key.Index), commitIndex)
+		}
+		modified = true
+	}
+	if modified {
+		atomic.StoreUint64(&txn.db.lastIndex, commitIndex)
+	}
+
 	for key, subTxn := range txn.content {
 		path := indexPath(key.Table, key.Index)
-		final := subTxn.Commit()
+		final := subTxn.CommitOnly()
 		txn.rootTxn.Insert(path, final)
 	}
 
-	newRoot := txn.rootTxn.Commit()
+	newRoot := txn.rootTxn.CommitOnly()
 	atomic.StorePointer(&txn.db.root, unsafe.Pointer(newRoot))
 
+	// Wake up the watchers only once the new root is visible
+	for _, subTxn := range txn.content {
+		subTxn.Notify()
+	}
+
 	txn.rootTxn = nil
 	txn.content = nil
+
+	txn.db.writer.Unlock()
+
+	// Run the deferred functions once the writer lock is released, so they
+	// are free to start transactions of their own
+	txn.runDeferred()
+	return nil
+}
+
+// runDeferred runs the deferred functions in LIFO order, only once.
+func (txn *Transaction) runDeferred() {
+	after := txn.after
+	txn.after = nil
+	for i := len(after); i > 0; i-- {
+		fn := after[i-1]
+		fn()
+	}
+}
+
+// rebase checks an optimistic transaction for conflicts and, if other
+// commits happened since it began, replays its operations on the latest
+// root. This must be called while holding the writer lock.
+func (txn *Transaction) rebase() error {
+	root := txn.db.getRoot()
+	if root == txn.startRoot {
+		return nil
+	}
+
+	// A row was modified concurrently if its commit index changed
+	rowIndexOf := func(t *tree.Tree, key rowKey) (interface{}, bool) {
+		raw, _ := t.Get(indexPath(key.Table, rowIndex))
+		return raw.(*tree.Tree).Get([]byte(key.ID))
+	}
+	conflicts := func(key rowKey) bool {
+		before, okBefore := rowIndexOf(txn.startRoot, key)
+		after, okAfter := rowIndexOf(root, key)
+		return okBefore != okAfter || before != after
+	}
+	for key := range txn.reads {
+		if conflicts(key) {
+			return ErrConflict
+		}
+	}
+	for _, key := range txn.writes {
+		if conflicts(key) {
+			return ErrConflict
+		}
+	}
+
+	replay := &Transaction{
+		db:       txn.db,
+		schema:   schemaOf(root),
+		writable: true,
+		rootTxn:  root.Transaction(),
+	}
+	for _, op := range txn.ops {
+		if err := op(replay); err != nil {
+			return err
+		}
+	}
+
+	txn.schema = replay.schema
+	txn.rootTxn = replay.rootTxn
+	txn.content = replay.content
+	txn.writes = replay.writes
+	return nil
+}
+
+// Defer is used to push a new function to be run once the transaction is
+// committed, in LIFO order. The functions are discarded if the transaction
+// is aborted. Read transactions run them when Commit is called.
+func (txn *Transaction) Defer(fn func()) {
+	txn.after = append(txn.after, fn)
 }
 
 // Insert is used to add or update an object into the given table.
 func (txn *Transaction) Insert(table string, obj interface{}) error {
-	tableSchema, ok := txn.db.schema.Tables[table]
+	if !txn.writable {
+		return fmt.Errorf("cannot insert in read-only transaction")
+	}
+
+	if err := txn.insert(table, obj); err != nil {
+		return err
+	}
+	txn.trackOp(func(t *Transaction) error {
+		return t.Insert(table, obj)
+	})
+	return nil
+}
+
+func (txn *Transaction) insert(table string, obj interface{}) error {
+	tableSchema, ok := txn.schema.Tables[table]
 	if !ok {
 		return fmt.Errorf("invalid table '%s'", table)
 	}
 
 	// Get the primary ID of the object
-	idSchema := tableSchema.Indexes[id]
-	idIndexer := idSchema.Indexer.(index.SingleIndexer)
-	ok, idVal, err := idIndexer.FromObject(obj)
+	idVal, err := primaryKey(tableSchema, obj)
+	if err != nil {
+		return err
+	}
+
+	// Lookup the object by ID first, to see if this is an update
+	idTxn := txn.write(table, id)
+	existing, update := idTxn.Get(idVal)
+
+	// Build and check every index value before writing anything, so a
+	// violated constraint leaves the table untouched
+	newValues := make(map[string][][]byte, len(tableSchema.Indexes))
+	for name, indexSchema := range tableSchema.Indexes {
+		ok, values, err := indexValues(indexSchema, obj, idVal)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", name, err)
+		}
+		if !ok {
+			if !indexSchema.AllowMissing {
+				return fmt.Errorf("missing value for index '%s'", name)
+			}
+			continue
+		}
+
+		if err := txn.checkUnique(tableSchema, indexSchema, idVal, values); err != nil {
+			return err
+		}
+		newValues[name] = values
+	}
+
+	if err := txn.checkReferences(table, obj); err != nil {
+		return err
+	}
+	if update {
+		if err := txn.checkReferencedUpdate(table, existing, obj); err != nil {
+			return err
+		}
+	}
+
+	for name, indexSchema := range tableSchema.Indexes {
+		indexTxn := txn.write(table, name)
+		values := newValues[name]
+
+		// Remove the entries of the previous version that no longer apply
+		if update {
+			okExist, valuesExist, err := indexValues(indexSchema, existing, idVal)
+			if err != nil {
+				return fmt.Errorf("failed to build index '%s': %v", name, err)
+			}
+			if okExist {
+				for _, valExist := range valuesExist {
+					if !containsValue(values, valExist) {
+						indexTxn.Delete(valExist)
+					}
+				}
+			}
+		}
+
+		for _, val := range values {
+			indexTxn.Insert(val, obj)
+		}
+	}
+
+	if update {
+		txn.trackChange(table, idVal, existing, obj)
+	} else {
+		txn.trackChange(table, idVal, nil, obj)
+	}
+	txn.trackWrite(table, idVal)
+	return nil
+}
+
+// Delete is used to delete a single object from the given table.
+// This object must already exist in the table. The rows referencing it
+// through a foreign key are handled according to the OnDelete action of the
+// foreign key.
+func (txn *Transaction) Delete(table string, obj interface{}) error {
+	if !txn.writable {
+		return fmt.Errorf("cannot delete in read-only transaction")
+	}
+
+	// The referential actions may fail after other rows were modified, so
+	// they run under a savepoint to be undone on error
+	var sp *Savepoint
+	if len(txn.schema.references(table)) > 0 {
+		sp = txn.Savepoint()
+	}
+	if err := txn.delete(table, obj); err != nil {
+		if sp != nil {
+			txn.RollbackTo(sp)
+			txn.releaseSavepoint(sp)
+		}
+		return err
+	}
+	if sp != nil {
+		txn.releaseSavepoint(sp)
+	}
+	txn.trackOp(func(t *Transaction) error {
+		return t.Delete(table, obj)
+	})
+	return nil
+}
+
+func (txn *Transaction) delete(table string, obj interface{}) error {
+	tableSchema, ok := txn.schema.Tables[table]
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+
+	// Get the primary ID of the object
+	idVal, err := primaryKey(tableSchema, obj)
 	if err != nil {
-		return fmt.Errorf("failed to build primary index: %v", err)
+		return err
 	}
+
+	// Lookup the stored object, it is what the index values were built from
+	idTxn := txn.write(table, id)
+	existing, ok := idTxn.Get(idVal)
 	if !ok {
-		return fmt.Errorf("object missing primary index")
+		return ErrNotFound
 	}
 
 	for name, indexSchema := range tableSchema.Indexes {
 		indexTxn := txn.write(table, name)
 
-		var (
-			ok     bool
-			values [][]byte
-			err    error
-		)
-		switch indexerType := indexSchema.Indexer.(type) {
-		case index.SingleIndexer:
-			var val []byte
-			ok, val, err = indexerType.FromObject(obj)
-			values = [][]byte{val}
-		case index.MultiIndexer:
-			ok, values, err = indexerType.FromObject(obj)
+		ok, values, err := indexValues(indexSchema, existing, idVal)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", name, err)
+		}
+		if !ok {
+			continue
 		}
+
+		for _, val := range values {
+			indexTxn.Delete(val)
+		}
+	}
+
+	txn.trackChange(table, idVal, existing, nil)
+	txn.trackWrite(table, idVal)
+
+	// The row is removed first, so rows referencing themselves or each other
+	// are only visited once
+	return txn.onDelete(table, existing)
+}
+
+// DeleteAll is used to delete all the objects in a given table matching
+// the constraints on the index. Returns the number of rows deleted.
+func (txn *Transaction) DeleteAll(table, index string, args ...interface{}) (int, error) {
+	if !txn.writable {
+		return 0, fmt.Errorf("cannot delete in read-only transaction")
+	}
+
+	iter, err := txn.Get(table, index, args...)
+	if err != nil {
+		return 0, err
+	}
+	return txn.deleteRows(table, iter)
+}
+
+// DeletePrefix is used to delete all the objects in a given table whose
+// value on the index starts with the given prefix. The index must support
+// prefix lookups. Returns the number of rows deleted.
+func (txn *Transaction) DeletePrefix(table, index string, prefix ...interface{}) (int, error) {
+	if !txn.writable {
+		return 0, fmt.Errorf("cannot delete in read-only transaction")
+	}
+
+	indexSchema, _, err := txn.getIndexValue(table, index)
+	if err != nil {
+		return 0, err
+	}
+
+	val, err := prefixIndexValue(indexSchema, prefix...)
+	if err != nil {
+		return 0, err
+	}
+
+	indexIter := txn.read(table, indexSchema.Name).Root().Iterator()
+	indexIter.SeekPrefix(val)
+	return txn.deleteRows(table, &radixIterator{iter: indexIter})
+}
+
+// trackWrite records a write to a row, its commit index is updated on commit.
+func (txn *Transaction) trackWrite(table string, idVal []byte) {
+	txn.writes = append(txn.writes, rowKey{table, string(idVal)})
+}
+
+// trackOp records an operation performed by an optimistic transaction, so it
+// can be replayed on commit. Only the operations called by the user are
+// recorded, the writes they cause are performed again when replaying them.
+func (txn *Transaction) trackOp(op func(*Transaction) error) {
+	if txn.optimistic {
+		txn.ops = append(txn.ops, op)
+	}
+}
+
+// trackRead records a row read by an optimistic transaction.
+func (txn *Transaction) trackRead(table string, obj interface{}) {
+	if !txn.optimistic {
+		return
+	}
+
+	idVal, err := primaryKey(txn.schema.Tables[table], obj)
+	if err != nil {
+		return
+	}
+	if txn.reads == nil {
+		txn.reads = make(map[rowKey]struct{})
+	}
+	txn.reads[rowKey{table, string(idVal)}] = struct{}{}
+}
+
+// trackReads wraps iter so the rows it returns are recorded as read by an
+// optimistic transaction.
+func (txn *Transaction) trackReads(table string, iter ResultIterator) ResultIterator {
+	if !txn.optimistic {
+		return iter
+	}
+	return &readTrackingIterator{
+		txn:   txn,
+		table: table,
+		iter:  iter,
+	}
+}
+
+// deleteRows deletes every object produced by iter. The objects are
+// gathered first so the deletes never observe a partially modified index.
+// The deletes are undone if one of them fails.
+func (txn *Transaction) deleteRows(table string, iter ResultIterator) (int, error) {
+	tableSchema := txn.schema.Tables[table]
+
+	// A MultiIndexer returns a row once per matching value
+	var objs []interface{}
+	seen := make(map[string]struct{})
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		idVal, err := primaryKey(tableSchema, obj)
 		if err != nil {
-			return fmt.Errorf("failed to build indexerType '%s': %v", name, err)
+			return 0, err
 		}
+		if _, ok := seen[string(idVal)]; ok {
+			continue
+		}
+		seen[string(idVal)] = struct{}{}
+		objs = append(objs, obj)
+	}
 
-		if ok {
-			for i := range values {
-				values[i] = append(values[i], idVal...)
-			}
+	sp := txn.Savepoint()
+	for _, obj := range objs {
+		// A row already deleted by the cascade of an earlier one still
+		// counts as deleted
+		if err := txn.Delete(table, obj); err != nil && err != ErrNotFound {
+			txn.RollbackTo(sp)
+			txn.releaseSavepoint(sp)
+			return 0, err
 		}
+	}
+	txn.releaseSavepoint(sp)
+	return len(objs), nil
+}
 
-		for _, val := range values {
-			indexTxn.Insert(val, obj)
+// checkUnique returns a UniqueConstraintError if one of the values is already
+// used in a unique index by another row than the one with the given primary ID.
+func (txn *Transaction) checkUnique(tableSchema *TableSchema, indexSchema *IndexSchema, idVal []byte, values [][]byte) error {
+	if !indexSchema.isUnique() || indexSchema.Name == id {
+		return nil
+	}
+
+	indexTxn := txn.write(tableSchema.Name, indexSchema.Name)
+	for _, val := range values {
+		other, found := indexTxn.Get(val)
+		if !found {
+			continue
+		}
+		otherID, err := primaryKey(tableSchema, other)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(otherID, idVal) {
+			return &UniqueConstraintError{Table: tableSchema.Name, Index: indexSchema.Name, Value: val}
 		}
 	}
 	return nil
 }
 
+// containsValue reports whether val is one of values.
+func containsValue(values [][]byte, val []byte) bool {
+	for _, v := range values {
+		if bytes.Equal(v, val) {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryKey returns the value of the id index for obj.
+func primaryKey(tableSchema *TableSchema, obj interface{}) ([]byte, error) {
+	idIndexer := tableSchema.Indexes[id].Indexer.(index.SingleIndexer)
+	ok, idVal, err := idIndexer.FromObject(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build primary index: %v", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("object missing primary index")
+	}
+	return idVal, nil
+}
+
+// indexValues returns the keys under which obj is stored in an index. Keys of
+// non-unique indexes are suffixed with the primary ID so that rows sharing an
+// index value remain distinct entries.
+func indexValues(indexSchema *IndexSchema, obj interface{}, idVal []byte) (bool, [][]byte, error) {
+	var (
+		ok     bool
+		values [][]byte
+		err    error
+	)
+	switch indexerType := indexSchema.Indexer.(type) {
+	case index.SingleIndexer:
+		var val []byte
+		ok, val, err = indexerType.FromObject(obj)
+		values = [][]byte{val}
+	case index.MultiIndexer:
+		ok, values, err = indexerType.FromObject(obj)
+	}
+	if err != nil || !ok {
+		return false, nil, err
+	}
+
+	if indexSchema.isUnique() {
+		return true, values, nil
+	}
+
+	keys := make([][]byte, len(values))
+	for i, val := range values {
+		keys[i] = make([]byte, 0, len(val)+len(idVal))
+		keys[i] = append(append(keys[i], val...), idVal...)
+	}
+	return true, keys, nil
+}
+
 func (txn *Transaction) getIndexValue(table, index string, args ...interface{}) (*IndexSchema, []byte, error) {
-	tableSchema, ok := txn.db.schema.Tables[table]
+	tableSchema, ok := txn.schema.Tables[table]
 	if !ok {
 		return nil, nil, fmt.Errorf("invalid table '%s'", table)
 	}
 
+	// An index name with the prefix suffix looks up the values starting
+	// with the args on the underlying index
 	indexSchema, ok := tableSchema.Indexes[index]
+	prefixScan := false
+	if !ok && strings.HasSuffix(index, prefixSuffix) {
+		indexSchema, ok = tableSchema.Indexes[strings.TrimSuffix(index, prefixSuffix)]
+		prefixScan = true
+	}
 	if !ok {
 		return nil, nil, fmt.Errorf("invalid index '%s'", index)
 	}
 
+	if prefixScan && !indexSchema.supportsPrefix() {
+		return nil, nil, fmt.Errorf("index '%s' does not support prefix lookups", indexSchema.Name)
+	}
+
 	if len(args) == 0 {
 		return indexSchema, nil, nil
 	}
 
+	if prefixScan {
+		val, err := prefixIndexValue(indexSchema, args...)
+		return indexSchema, val, err
+	}
+
 	val, err := indexSchema.Indexer.FromArgs(args...)
 	if err != nil {
 		return indexSchema, nil, fmt.Errorf("index error: %v", err)
@@ -157,6 +706,113 @@ func (txn *Transaction) getIndexValue(table, index string, args ...interface{})
 	return indexSchema, val, err
 }
 
+// First is used to return the first matching object for the given
+// constraints on the index. Returns ErrNotFound if nothing matches.
+func (txn *Transaction) First(table, index string, args ...interface{}) (interface{}, error) {
+	return txn.lookupOne(table, index, false, args...)
+}
+
+// Last is used to return the last matching object for the given
+// constraints on the index. Returns ErrNotFound if nothing matches.
+func (txn *Transaction) Last(table, index string, args ...interface{}) (interface{}, error) {
+	return txn.lookupOne(table, index, true, args...)
+}
+
+// lookupOne returns the first matching object in the order of the index, or
+// the last one if reverse is set.
+func (txn *Transaction) lookupOne(table, index string, reverse bool, args ...interface{}) (interface{}, error) {
+	indexSchema, val, err := txn.getIndexValue(table, index, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	indexTxn := txn.read(table, indexSchema.Name)
+
+	// Keys of unique indexes are exact, so a complete value can be looked
+	// up directly. Otherwise it is a prefix of the keys to scan.
+	if indexSchema.isUnique() && val != nil {
+		if obj, ok := indexTxn.Get(val); ok {
+			txn.trackRead(table, obj)
+			return obj, nil
+		}
+	}
+
+	var (
+		obj interface{}
+		ok  bool
+	)
+	if reverse {
+		indexIter := indexTxn.Root().ReverseIterator()
+		indexIter.SeekPrefix(val)
+		_, obj, ok = indexIter.Previous()
+	} else {
+		indexIter := indexTxn.Root().Iterator()
+		indexIter.SeekPrefix(val)
+		_, obj, ok = indexIter.Next()
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	txn.trackRead(table, obj)
+	return obj, nil
+}
+
+// prefixIndexValue builds the prefix of the keys matching args on an index
+// that implements index.PrefixIndexer.
+func prefixIndexValue(indexSchema *IndexSchema, args ...interface{}) ([]byte, error) {
+	if !indexSchema.supportsPrefix() {
+		return nil, fmt.Errorf("index '%s' does not support prefix lookups", indexSchema.Name)
+	}
+
+	val, err := indexSchema.Indexer.(index.PrefixIndexer).PrefixFromArgs(args...)
+	if err != nil {
+		return nil, fmt.Errorf("index error: %v", err)
+	}
+	return val, nil
+}
+
+// Count returns the number of index entries matching the given constraints
+// of an index, without iterating over them. An object indexed by a
+// MultiIndexer is counted once for every one of its values that matches.
+func (txn *Transaction) Count(table, index string, args ...interface{}) (int, error) {
+	indexSchema, val, err := txn.getIndexValue(table, index, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	indexTxn := txn.read(table, indexSchema.Name)
+	return indexTxn.Root().CountPrefix(val), nil
+}
+
+// LastIndex returns the index of the last commit that modified any of the
+// given tables, or any table at all if none are given. Returns zero if the
+// tables were never modified. The uncommitted writes of this transaction are
+// not taken into account.
+func (txn *Transaction) LastIndex(tables ...string) uint64 {
+	if len(tables) == 0 {
+		for table := range txn.schema.Tables {
+			tables = append(tables, table)
+		}
+	}
+
+	var last uint64
+	for _, table := range tables {
+		if raw, ok := txn.rootTxn.Get(lastIndexPath(table, "")); ok && raw.(uint64) > last {
+			last = raw.(uint64)
+		}
+	}
+	return last
+}
+
+// LastIndexOf returns the index of the last commit that modified the given
+// index of a table, or zero if it was never modified.
+func (txn *Transaction) LastIndexOf(table, index string) uint64 {
+	if raw, ok := txn.rootTxn.Get(lastIndexPath(table, index)); ok {
+		return raw.(uint64)
+	}
+	return 0
+}
+
 // ResultIterator is used to iterate over a list of results from a query on a table.
 type ResultIterator interface {
 	Next() interface{}
@@ -174,7 +830,118 @@ func (txn *Transaction) Get(table, index string, args ...interface{}) (ResultIte
 	iter := &radixIterator{
 		iter: indexIter,
 	}
-	return iter, nil
+	return txn.trackReads(table, iter), nil
+}
+
+// GetWatch is like Get but also returns a watch channel that is closed once
+// a commit modifies any of the rows matching the given constraints.
+func (txn *Transaction) GetWatch(table, index string, args ...interface{}) (<-chan struct{}, ResultIterator, error) {
+	indexIter, val, err := txn.getIndexIterator(table, index, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	watchCh := indexIter.SeekPrefixWatch(val)
+
+	iter := &radixIterator{
+		iter: indexIter,
+	}
+	return watchCh, txn.trackReads(table, iter), nil
+}
+
+// LowerBound is used to construct a ResultIterator over all the rows that
+// have an index value greater than or equal to the provided args, in
+// ascending order of the index.
+func (txn *Transaction) LowerBound(table, index string, args ...interface{}) (ResultIterator, error) {
+	indexIter, val, err := txn.getIndexIterator(table, index, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	indexIter.SeekLowerBound(val)
+
+	iter := &radixIterator{
+		iter: indexIter,
+	}
+	return txn.trackReads(table, iter), nil
+}
+
+// Range is used to construct a ResultIterator over all the rows whose index
+// value is greater than or equal to from and strictly less than to, in
+// ascending order of the index. A nil from starts at the beginning of the
+// index and a nil to runs until its end.
+func (txn *Transaction) Range(table, index string, from, to []interface{}) (ResultIterator, error) {
+	indexIter, lower, err := txn.getIndexIterator(table, index, from...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, upper, err := txn.getIndexValue(table, index, to...)
+	if err != nil {
+		return nil, err
+	}
+
+	indexIter.SeekLowerBound(lower)
+
+	iter := &rangeIterator{
+		iter:  indexIter,
+		upper: upper,
+	}
+	return txn.trackReads(table, iter), nil
+}
+
+// GetReverse is used to construct a ResultIterator over all the rows that
+// match the given constraints of an index, in descending order of the index.
+func (txn *Transaction) GetReverse(table, index string, args ...interface{}) (ResultIterator, error) {
+	indexIter, val, err := txn.getIndexIteratorReverse(table, index, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	indexIter.SeekPrefix(val)
+
+	iter := &radixReverseIterator{
+		iter: indexIter,
+	}
+	return txn.trackReads(table, iter), nil
+}
+
+// ReverseLowerBound is used to construct a ResultIterator over all the rows
+// that have an index value less than or equal to the provided args, in
+// descending order of the index.
+func (txn *Transaction) ReverseLowerBound(table, index string, args ...interface{}) (ResultIterator, error) {
+	indexIter, val, err := txn.getIndexIteratorReverse(table, index, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The keys equal to args are the ones starting with val, as keys of
+	// non-unique indexes are suffixed with the primary ID. They all sort
+	// before the successor of val, which is left out.
+	upper := prefixSuccessor(val)
+	if upper != nil {
+		indexIter.SeekReverseLowerBound(upper)
+	}
+
+	iter := &reverseRangeIterator{
+		iter:  indexIter,
+		upper: upper,
+	}
+	return txn.trackReads(table, iter), nil
+}
+
+// prefixSuccessor returns the smallest key greater than every key starting
+// with prefix, or nil if there is none.
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			succ := make([]byte, i+1)
+			copy(succ, prefix)
+			succ[i]++
+			return succ
+		}
+	}
+	return nil
 }
 
 func (txn *Transaction) getIndexIterator(table, index string, args ...interface{}) (*tree.Iterator, []byte, error) {
@@ -190,6 +957,19 @@ func (txn *Transaction) getIndexIterator(table, index string, args ...interface{
 	return indexIter, val, nil
 }
 
+func (txn *Transaction) getIndexIteratorReverse(table, index string, args ...interface{}) (*tree.ReverseIterator, []byte, error) {
+	indexSchema, val, err := txn.getIndexValue(table, index, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexTxn := txn.read(table, indexSchema.Name)
+	indexRoot := indexTxn.Root()
+
+	indexIter := indexRoot.ReverseIterator()
+	return indexIter, val, nil
+}
+
 type radixIterator struct {
 	iter *tree.Iterator
 }
@@ -201,3 +981,66 @@ func (r *radixIterator) Next() interface{} {
 	}
 	return value
 }
+
+type radixReverseIterator struct {
+	iter *tree.ReverseIterator
+}
+
+func (r *radixReverseIterator) Next() interface{} {
+	_, value, ok := r.iter.Previous()
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+// rangeIterator stops an ascending iteration at an exclusive upper bound.
+type rangeIterator struct {
+	iter  *tree.Iterator
+	upper []byte
+}
+
+func (r *rangeIterator) Next() interface{} {
+	key, value, ok := r.iter.Next()
+	if !ok {
+		return nil
+	}
+	if r.upper != nil && bytes.Compare(key, r.upper) >= 0 {
+		return nil
+	}
+	return value
+}
+
+// reverseRangeIterator skips the keys greater than or equal to upper
+type reverseRangeIterator struct {
+	iter  *tree.ReverseIterator
+	upper []byte
+}
+
+func (r *reverseRangeIterator) Next() interface{} {
+	for {
+		key, value, ok := r.iter.Previous()
+		if !ok {
+			return nil
+		}
+		if r.upper != nil && bytes.Compare(key, r.upper) >= 0 {
+			continue
+		}
+		return value
+	}
+}
+
+// readTrackingIterator records the rows returned to an optimistic transaction
+type readTrackingIterator struct {
+	txn   *Transaction
+	table string
+	iter  ResultIterator
+}
+
+func (r *readTrackingIterator) Next() interface{} {
+	obj := r.iter.Next()
+	if obj != nil {
+		r.txn.trackRead(r.table, obj)
+	}
+	return obj
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"reflect"
+)
+
+// WatchSet is a collection of watch channels, used to wait until any of the
+// watched query results change.
+type WatchSet map[<-chan struct{}]struct{}
+
+// NewWatchSet constructs a new watch set.
+func NewWatchSet() WatchSet {
+	return make(map[<-chan struct{}]struct{})
+}
+
+// Add appends a watchCh to the WatchSet if non-nil.
+func (w WatchSet) Add(watchCh <-chan struct{}) {
+	if w == nil || watchCh == nil {
+		return
+	}
+
+	w[watchCh] = struct{}{}
+}
+
+// Watch blocks until one of the channels in the watch set is closed, or
+// ctx is done. Returns ctx.Err() if the context finished first.
+func (w WatchSet) Watch(ctx context.Context) error {
+	cases := make([]reflect.SelectCase, 0, len(w)+1)
+	cases = append(cases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ctx.Done()),
+	})
+	for watchCh := range w {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(watchCh),
+		})
+	}
+
+	chosen, _, _ := reflect.Select(cases)
+	if chosen == 0 {
+		return ctx.Err()
+	}
+	return nil
+}
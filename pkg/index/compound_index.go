@@ -0,0 +1,103 @@
+package index
+
+import (
+	"fmt"
+)
+
+// CompoundIndex is used to build an index using multiple sub-indexes. The key
+// of an object is the concatenation of the keys of the sub-indexes, so rows
+// can be looked up by all the values or by a leading subset of them.
+// Every sub-index must be a SingleIndexer, except the last one which may be
+// a MultiIndexer, in which case a key is built for each of its values.
+type CompoundIndex struct {
+	Indexes []Indexer
+}
+
+func (c *CompoundIndex) FromObject(raw interface{}) (bool, [][]byte, error) {
+	var prefix []byte
+	for i, idx := range c.Indexes {
+		switch indexer := idx.(type) {
+		case SingleIndexer:
+			ok, val, err := indexer.FromObject(raw)
+			if err != nil {
+				return false, nil, fmt.Errorf("sub-index %d error: %v", i, err)
+			}
+			if !ok {
+				return false, nil, nil
+			}
+			prefix = append(prefix, val...)
+
+		case MultiIndexer:
+			if i != len(c.Indexes)-1 {
+				return false, nil, fmt.Errorf("sub-index %d is a MultiIndexer but is not the last sub-index", i)
+			}
+
+			ok, vals, err := indexer.FromObject(raw)
+			if err != nil {
+				return false, nil, fmt.Errorf("sub-index %d error: %v", i, err)
+			}
+			if !ok {
+				return false, nil, nil
+			}
+
+			keys := make([][]byte, len(vals))
+			for j, val := range vals {
+				keys[j] = make([]byte, 0, len(prefix)+len(val))
+				keys[j] = append(append(keys[j], prefix...), val...)
+			}
+			return true, keys, nil
+
+		default:
+			return false, nil, fmt.Errorf("sub-index %d must be a SingleIndexer or MultiIndexer", i)
+		}
+	}
+	return true, [][]byte{prefix}, nil
+}
+
+// FromArgs builds the key from one argument per sub-index. Passing fewer
+// arguments than there are sub-indexes builds a key for the leading
+// sub-indexes only, which can be used as a prefix.
+func (c *CompoundIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) == 0 || len(args) > len(c.Indexes) {
+		return nil, fmt.Errorf("must provide between 1 and %d arguments", len(c.Indexes))
+	}
+
+	var out []byte
+	for i, arg := range args {
+		val, err := c.Indexes[i].FromArgs(arg)
+		if err != nil {
+			return nil, fmt.Errorf("sub-index %d error: %v", i, err)
+		}
+		out = append(out, val...)
+	}
+	return out, nil
+}
+
+// PrefixFromArgs builds the key from the leading arguments as FromArgs does,
+// except that the last argument is a prefix of its sub-index value. That
+// sub-index must be a PrefixIndexer.
+func (c *CompoundIndex) PrefixFromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) == 0 || len(args) > len(c.Indexes) {
+		return nil, fmt.Errorf("must provide between 1 and %d arguments", len(c.Indexes))
+	}
+
+	var out []byte
+	last := len(args) - 1
+	for i, arg := range args[:last] {
+		val, err := c.Indexes[i].FromArgs(arg)
+		if err != nil {
+			return nil, fmt.Errorf("sub-index %d error: %v", i, err)
+		}
+		out = append(out, val...)
+	}
+
+	prefixIndexer, ok := c.Indexes[last].(PrefixIndexer)
+	if !ok {
+		return nil, fmt.Errorf("sub-index %d does not support prefix lookups", last)
+	}
+	val, err := prefixIndexer.PrefixFromArgs(args[last])
+	if err != nil {
+		return nil, fmt.Errorf("sub-index %d error: %v", last, err)
+	}
+	return append(out, val...), nil
+}
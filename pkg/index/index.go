@@ -30,6 +30,10 @@ type MultiIndexer interface {
 	FromObject(raw interface{}) (bool, [][]byte, error)
 }
 
-
-
-
+// PrefixIndexer is an optional interface on top of an Indexer that allows
+// indexes to support prefix-based lookups.
+type PrefixIndexer interface {
+	// PrefixFromArgs is the same as FromArgs for an Indexer except that the
+	// index value returned should return all prefix-matched values.
+	PrefixFromArgs(args ...interface{}) ([]byte, error)
+}
@@ -3,11 +3,13 @@ package index
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 	"reflect"
 )
 
 // IntFieldIndex is used to extract an int field from an object using
-// reflection and builds an index on that field.
+// reflection and builds an index on that field. Values are encoded so that
+// the index sorts in numeric order, including negative values.
 type IntFieldIndex struct {
 	Field string
 }
@@ -24,17 +26,11 @@ func (i *IntFieldIndex) FromObject(obj interface{}) (bool, []byte, error) {
 
 	// Check the type
 	k := fv.Kind()
-	size, ok := IsIntType(k)
-	if !ok {
+	if _, ok := IsIntType(k); !ok {
 		return false, nil, fmt.Errorf("field %q is of type %v; want an int", i.Field, k)
 	}
 
-	// Get the value and encode it
-	val := fv.Int()
-	buf := make([]byte, size)
-	binary.PutVarint(buf, val)
-
-	return true, buf, nil
+	return true, encodeInt(fv.Int()), nil
 }
 
 func (i *IntFieldIndex) FromArgs(args ...interface{}) ([]byte, error) {
@@ -47,33 +43,43 @@ func (i *IntFieldIndex) FromArgs(args ...interface{}) ([]byte, error) {
 		return nil, fmt.Errorf("%#v is invalid", args[0])
 	}
 
+	// Any integer kind is accepted, so the caller does not need to match
+	// the type of the field
 	k := v.Kind()
-	size, ok := IsIntType(k)
-	if !ok {
-		return nil, fmt.Errorf("arg is of type %v; want a int", k)
+	if _, ok := IsIntType(k); ok {
+		return encodeInt(v.Int()), nil
 	}
+	if _, ok := IsUintType(k); ok {
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("arg %d overflows an int", v.Uint())
+		}
+		return encodeInt(int64(v.Uint())), nil
+	}
+	return nil, fmt.Errorf("arg is of type %v; want a int", k)
+}
 
-	val := v.Int()
-	buf := make([]byte, size)
-	binary.PutVarint(buf, val)
-
-	return buf, nil
+// encodeInt encodes val as big endian with the sign bit flipped, so that the
+// byte order of the encoded values matches their numeric order.
+func encodeInt(val int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(val)^(1<<63))
+	return buf
 }
 
 // IsIntType returns whether the passed type is a type of int and the number
-// of bytes needed to encode the type.
+// of bytes of the type.
 func IsIntType(k reflect.Kind) (size int, okay bool) {
 	switch k {
 	case reflect.Int:
-		return binary.MaxVarintLen64, true
+		return 8, true
 	case reflect.Int8:
-		return 2, true
+		return 1, true
 	case reflect.Int16:
-		return binary.MaxVarintLen16, true
+		return 2, true
 	case reflect.Int32:
-		return binary.MaxVarintLen32, true
+		return 4, true
 	case reflect.Int64:
-		return binary.MaxVarintLen64, true
+		return 8, true
 	default:
 		return 0, false
 	}
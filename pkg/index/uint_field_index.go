@@ -0,0 +1,85 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// UintFieldIndex is used to extract a uint field from an object using
+// reflection and builds an index on that field. Values are encoded so that
+// the index sorts in numeric order.
+type UintFieldIndex struct {
+	Field string
+}
+
+func (u *UintFieldIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	v := reflect.ValueOf(obj)
+	v = reflect.Indirect(v) // Dereference the pointer if any
+
+	fv := v.FieldByName(u.Field)
+	if !fv.IsValid() {
+		return false, nil,
+			fmt.Errorf("field '%s' for %#v is invalid", u.Field, obj)
+	}
+
+	// Check the type
+	k := fv.Kind()
+	if _, ok := IsUintType(k); !ok {
+		return false, nil, fmt.Errorf("field %q is of type %v; want a uint", u.Field, k)
+	}
+
+	return true, encodeUint(fv.Uint()), nil
+}
+
+func (u *UintFieldIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+
+	v := reflect.ValueOf(args[0])
+	if !v.IsValid() {
+		return nil, fmt.Errorf("%#v is invalid", args[0])
+	}
+
+	// Any integer kind is accepted, so the caller does not need to match
+	// the type of the field
+	k := v.Kind()
+	if _, ok := IsUintType(k); ok {
+		return encodeUint(v.Uint()), nil
+	}
+	if _, ok := IsIntType(k); ok {
+		if v.Int() < 0 {
+			return nil, fmt.Errorf("arg %d is negative; want a uint", v.Int())
+		}
+		return encodeUint(uint64(v.Int())), nil
+	}
+	return nil, fmt.Errorf("arg is of type %v; want a uint", k)
+}
+
+// encodeUint encodes val as big endian, so that the byte order of the
+// encoded values matches their numeric order.
+func encodeUint(val uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, val)
+	return buf
+}
+
+// IsUintType returns whether the passed type is a type of uint and the number
+// of bytes of the type.
+func IsUintType(k reflect.Kind) (size int, okay bool) {
+	switch k {
+	case reflect.Uint:
+		return 8, true
+	case reflect.Uint8:
+		return 1, true
+	case reflect.Uint16:
+		return 2, true
+	case reflect.Uint32:
+		return 4, true
+	case reflect.Uint64:
+		return 8, true
+	default:
+		return 0, false
+	}
+}
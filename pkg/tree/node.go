@@ -23,15 +23,32 @@ type edge struct {
 
 // Node is an immutable node in the radix tree
 type Node struct {
-	leaf *leafNode
+	// mutateCh is closed if this node is modified
+	mutateCh chan struct{}
+
+	leaf   *leafNode
 	prefix []byte
-	edges edges
+	edges  edges
+
+	// size is the number of leaves in the subtree rooted at this node
+	size int
 }
 
 func (n *Node) isLeaf() bool {
 	return n.leaf != nil
 }
 
+// updateSize recomputes the number of leaves under n from its children.
+func (n *Node) updateSize() {
+	n.size = 0
+	if n.isLeaf() {
+		n.size++
+	}
+	for _, e := range n.edges {
+		n.size += e.node.size
+	}
+}
+
 func (n *Node) addEdge(e edge) {
 	num := len(n.edges)
 	idx := sort.Search(num, func(i int) bool {
@@ -56,6 +73,31 @@ func (n *Node) replaceEdge(e edge) {
 	panic("replacing missing edge")
 }
 
+// getLowerBoundEdge returns the first edge whose label is greater than or
+// equal to the given label.
+func (n *Node) getLowerBoundEdge(label byte) (int, *Node) {
+	num := len(n.edges)
+	idx := sort.Search(num, func(i int) bool {
+		return n.edges[i].label >= label
+	})
+	if idx < num {
+		return idx, n.edges[idx].node
+	}
+	return -1, nil
+}
+
+func (n *Node) delEdge(label byte) {
+	num := len(n.edges)
+	idx := sort.Search(num, func(i int) bool {
+		return n.edges[i].label >= label
+	})
+	if idx < num && n.edges[idx].label == label {
+		copy(n.edges[idx:], n.edges[idx+1:])
+		n.edges[len(n.edges)-1] = edge{}
+		n.edges = n.edges[:len(n.edges)-1]
+	}
+}
+
 func (n *Node) getEdge(label byte) (int, *Node) {
 	num := len(n.edges)
 	idx := sort.Search(num, func(i int) bool {
@@ -67,12 +109,16 @@ func (n *Node) getEdge(label byte) (int, *Node) {
 	return -1, nil
 }
 
-func (n *Node) GetWatch(k []byte) (interface{}, bool) {
+// GetWatch is used to lookup a specific key, returning the watch channel,
+// value and if it was found. If the key is not found, the returned channel
+// belongs to the deepest node visited, so it fires once the key is added.
+func (n *Node) GetWatch(k []byte) (<-chan struct{}, interface{}, bool) {
 	search := k
+	watch := n.mutateCh
 	for {
 		if len(search) == 0 {
 			if n.isLeaf() {
-				return n.leaf.val, true
+				return n.leaf.mutateCh, n.leaf.val, true
 			}
 			break
 		}
@@ -82,20 +128,55 @@ func (n *Node) GetWatch(k []byte) (interface{}, bool) {
 			break
 		}
 
+		// Update to the finest granularity as the search makes progress
+		watch = n.mutateCh
+
 		if bytes.HasPrefix(search, n.prefix) {
 			search = search[len(n.prefix):]
 		} else {
 			break
 		}
 	}
-	return nil, false
+	return watch, nil, false
 }
 
+// CountPrefix returns the number of keys starting with the given prefix. It
+// uses the leaf counts cached on every node, so it only walks down to the
+// prefix.
+func (n *Node) CountPrefix(prefix []byte) int {
+	search := prefix
+	for {
+		if len(search) == 0 {
+			return n.size
+		}
+
+		_, n = n.getEdge(search[0])
+		if n == nil {
+			return 0
+		}
+
+		if bytes.HasPrefix(search, n.prefix) {
+			search = search[len(n.prefix):]
+		} else if bytes.HasPrefix(n.prefix, search) {
+			return n.size
+		} else {
+			return 0
+		}
+	}
+}
+
+// Get is used to lookup a specific key, returning the value and if it was found
 func (n *Node) Get(k []byte) (interface{}, bool) {
-	val, ok := n.GetWatch(k)
+	_, val, ok := n.GetWatch(k)
 	return val, ok
 }
 
 func (n *Node) Iterator() *Iterator {
 	return &Iterator{node: n}
-}
\ No newline at end of file
+}
+
+// ReverseIterator is used to return an iterator at
+// the given node to walk the tree backwards
+func (n *Node) ReverseIterator() *ReverseIterator {
+	return NewReverseIterator(n)
+}
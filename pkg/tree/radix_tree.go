@@ -1,5 +1,9 @@
 package tree
 
+import (
+	"bytes"
+)
+
 // Tree implements an radix tree. This can be treated as a Dictionary abstract data type.
 // The main advantage over a standard hash map is ordered iteration.
 type Tree struct {
@@ -9,14 +13,30 @@ type Tree struct {
 
 func New() *Tree {
 	t := &Tree{
-		root: &Node{},
+		root: &Node{
+			mutateCh: make(chan struct{}),
+		},
 	}
 	return t
 }
 
+// Transaction is a transaction on the tree. A new tree is only created once
+// Commit is called, the original tree is never modified.
 type Transaction struct {
 	root *Node
 	size int
+
+	// trackChannels holds the mutation channels of the nodes and leaves
+	// replaced by this transaction, which are closed by Notify. trackOrder
+	// lists them in the order they were tracked, so Restore can untrack the
+	// ones tracked after a clone.
+	trackChannels map[chan struct{}]struct{}
+	trackOrder    []chan struct{}
+	trackMutate   bool
+
+	// tracked is the number of channels tracked by the transaction this one
+	// was cloned from, when it was cloned
+	tracked int
 }
 
 func (t *Tree) Transaction() *Transaction {
@@ -27,17 +47,67 @@ func (t *Tree) Transaction() *Transaction {
 	return txn
 }
 
+// Clone makes an independent copy of the transaction. The clone does not
+// track any mutation channels.
 func (t *Transaction) Clone() *Transaction {
 	txn := &Transaction{
-		root: t.root,
-		size: t.size,
+		root:    t.root,
+		size:    t.size,
+		tracked: len(t.trackOrder),
 	}
 	return txn
 }
 
-func (t *Transaction) writeNode(n *Node) *Node {
+// Restore resets the transaction to the contents of c, which is expected to
+// be a Clone of it taken earlier. The mutation channels tracked since the
+// clone was taken are untracked, as their nodes are back in the tree, while
+// the nodes modified before it are still notified.
+func (t *Transaction) Restore(c *Transaction) {
+	t.root = c.root
+	t.size = c.size
+	if c.tracked < len(t.trackOrder) {
+		for _, ch := range t.trackOrder[c.tracked:] {
+			delete(t.trackChannels, ch)
+		}
+		t.trackOrder = t.trackOrder[:c.tracked]
+	}
+}
+
+// TrackMutate can be used to toggle if mutations are tracked. If this is enabled
+// then notifications will be issued for affected internal nodes and leaves when
+// the transaction is committed.
+func (t *Transaction) TrackMutate(track bool) {
+	t.trackMutate = track
+}
+
+// trackChannel safely attempts to track the given mutation channel.
+func (t *Transaction) trackChannel(ch chan struct{}) {
+	if !t.trackMutate {
+		return
+	}
+	if t.trackChannels == nil {
+		t.trackChannels = make(map[chan struct{}]struct{})
+	}
+	if _, ok := t.trackChannels[ch]; ok {
+		return
+	}
+	t.trackChannels[ch] = struct{}{}
+	t.trackOrder = append(t.trackOrder, ch)
+}
+
+// writeNode returns a copy of n with a fresh mutation channel, tracking the
+// channel of n. forLeafUpdate also tracks the leaf channel, which should only
+// be set when the copy's leaf is about to be replaced or removed.
+func (t *Transaction) writeNode(n *Node, forLeafUpdate bool) *Node {
+	t.trackChannel(n.mutateCh)
+	if forLeafUpdate && n.leaf != nil {
+		t.trackChannel(n.leaf.mutateCh)
+	}
+
 	nc := &Node{
+		mutateCh: make(chan struct{}),
 		leaf:     n.leaf,
+		size:     n.size,
 	}
 	if n.prefix != nil {
 		nc.prefix = make([]byte, len(n.prefix))
@@ -60,12 +130,13 @@ func (t *Transaction) insert(n *Node, k, search []byte, v interface{}) (*Node, i
 			didUpdate = true
 		}
 
-		nc := t.writeNode(n)
+		nc := t.writeNode(n, true)
 		nc.leaf = &leafNode{
 			mutateCh: make(chan struct{}),
 			key:      k,
 			val:      v,
 		}
+		nc.updateSize()
 		return nc, oldVal, didUpdate
 	}
 
@@ -75,15 +146,19 @@ func (t *Transaction) insert(n *Node, k, search []byte, v interface{}) (*Node, i
 		e := edge{
 			label: search[0],
 			node: &Node{
+				mutateCh: make(chan struct{}),
 				leaf: &leafNode{
+					mutateCh: make(chan struct{}),
 					key:      k,
 					val:      v,
 				},
 				prefix: search,
+				size:   1,
 			},
 		}
-		nc := t.writeNode(n)
+		nc := t.writeNode(n, false)
 		nc.addEdge(e)
+		nc.updateSize()
 		return nc, nil, false
 	}
 
@@ -92,15 +167,17 @@ func (t *Transaction) insert(n *Node, k, search []byte, v interface{}) (*Node, i
 		search = search[commonPrefix:]
 		newChild, oldVal, didUpdate := t.insert(child, k, search, v)
 		if newChild != nil {
-			nc := t.writeNode(n)
+			nc := t.writeNode(n, false)
 			nc.edges[idx].node = newChild
+			nc.updateSize()
 			return nc, oldVal, didUpdate
 		}
 		return nil, oldVal, didUpdate
 	}
 
-	nc := t.writeNode(n)
+	nc := t.writeNode(n, false)
 	splitNode := &Node{
+		mutateCh: make(chan struct{}),
 		prefix:   search[:commonPrefix],
 	}
 	nc.replaceEdge(edge{
@@ -108,7 +185,7 @@ func (t *Transaction) insert(n *Node, k, search []byte, v interface{}) (*Node, i
 		node:  splitNode,
 	})
 
-	modChild := t.writeNode(child)
+	modChild := t.writeNode(child, false)
 	splitNode.addEdge(edge{
 		label: modChild.prefix[commonPrefix],
 		node:  modChild,
@@ -124,16 +201,19 @@ func (t *Transaction) insert(n *Node, k, search []byte, v interface{}) (*Node, i
 	search = search[commonPrefix:]
 	if len(search) == 0 {
 		splitNode.leaf = leaf
-		return nc, nil, false
+	} else {
+		splitNode.addEdge(edge{
+			label: search[0],
+			node: &Node{
+				mutateCh: make(chan struct{}),
+				leaf:     leaf,
+				prefix:   search,
+				size:     1,
+			},
+		})
 	}
-
-	splitNode.addEdge(edge{
-		label: search[0],
-		node: &Node{
-			leaf:     leaf,
-			prefix:   search,
-		},
-	})
+	splitNode.updateSize()
+	nc.updateSize()
 	return nc, nil, false
 }
 
@@ -148,6 +228,140 @@ func (t *Transaction) Insert(k []byte, v interface{}) (interface{}, bool) {
 	return oldVal, didUpdate
 }
 
+func (t *Transaction) delete(n *Node, search []byte) (*Node, *leafNode) {
+	if len(search) == 0 {
+		if !n.isLeaf() {
+			return nil, nil
+		}
+		oldLeaf := n.leaf
+
+		nc := t.writeNode(n, true)
+		nc.leaf = nil
+
+		// Merge with the only child so the tree stays path compressed
+		if n != t.root && len(nc.edges) == 1 {
+			t.mergeChild(nc)
+		}
+		nc.updateSize()
+		return nc, oldLeaf
+	}
+
+	label := search[0]
+	idx, child := n.getEdge(label)
+	if child == nil || !bytes.HasPrefix(search, child.prefix) {
+		return nil, nil
+	}
+
+	search = search[len(child.prefix):]
+	newChild, leaf := t.delete(child, search)
+	if newChild == nil {
+		return nil, nil
+	}
+
+	nc := t.writeNode(n, false)
+	if newChild.leaf == nil && len(newChild.edges) == 0 {
+		nc.delEdge(label)
+		if n != t.root && len(nc.edges) == 1 && !nc.isLeaf() {
+			t.mergeChild(nc)
+		}
+	} else {
+		nc.edges[idx].node = newChild
+	}
+	nc.updateSize()
+	return nc, leaf
+}
+
+func (t *Transaction) deletePrefix(n *Node, search []byte) (*Node, int) {
+	if len(search) == 0 {
+		nc := t.writeNode(n, true)
+		nc.leaf = nil
+		nc.edges = nil
+		nc.size = 0
+
+		// The leaves are counted on every node, so the subtree only needs
+		// to be walked to collect its channels
+		if t.trackMutate {
+			t.trackSubtree(n)
+		}
+		return nc, n.size
+	}
+
+	label := search[0]
+	idx, child := n.getEdge(label)
+	// The search may end in the middle of the child's prefix, in which case
+	// the whole child subtree matches the prefix.
+	if child == nil || (!bytes.HasPrefix(child.prefix, search) && !bytes.HasPrefix(search, child.prefix)) {
+		return nil, 0
+	}
+
+	if len(child.prefix) > len(search) {
+		search = nil
+	} else {
+		search = search[len(child.prefix):]
+	}
+	newChild, numDeletions := t.deletePrefix(child, search)
+	if newChild == nil {
+		return nil, 0
+	}
+
+	nc := t.writeNode(n, false)
+	if newChild.leaf == nil && len(newChild.edges) == 0 {
+		nc.delEdge(label)
+		if n != t.root && len(nc.edges) == 1 && !nc.isLeaf() {
+			t.mergeChild(nc)
+		}
+	} else {
+		nc.edges[idx].node = newChild
+	}
+	nc.updateSize()
+	return nc, numDeletions
+}
+
+// mergeChild collapses the only child of n into n itself.
+func (t *Transaction) mergeChild(n *Node) {
+	child := n.edges[0].node
+	t.trackChannel(child.mutateCh)
+	n.prefix = concat(n.prefix, child.prefix)
+	n.leaf = child.leaf
+	if len(child.edges) != 0 {
+		n.edges = make([]edge, len(child.edges))
+		copy(n.edges, child.edges)
+	} else {
+		n.edges = nil
+	}
+}
+
+// Delete is used to delete a given key. Returns the old value if any,
+// and a bool indicating if the key was set.
+func (t *Transaction) Delete(k []byte) (interface{}, bool) {
+	newRoot, leaf := t.delete(t.root, k)
+	if newRoot != nil {
+		t.root = newRoot
+	}
+	if leaf != nil {
+		t.size--
+		return leaf.val, true
+	}
+	return nil, false
+}
+
+// DeletePrefix is used to delete an entire subtree that matches the prefix.
+// Returns true if any keys were deleted.
+func (t *Transaction) DeletePrefix(prefix []byte) bool {
+	newRoot, numDeletions := t.deletePrefix(t.root, prefix)
+	if newRoot != nil {
+		t.root = newRoot
+		t.size -= numDeletions
+		return true
+	}
+	return false
+}
+
+// Len returns the number of keys in the tree within this transaction.
+func (t *Transaction) Len() int {
+	return t.size
+}
+
 // Root returns the current root of the radix tree within this transaction.
 func (t *Transaction) Root() *Node {
 	return t.root
@@ -158,12 +372,31 @@ func (t *Transaction) Get(k []byte) (interface{}, bool) {
 	return t.root.Get(k)
 }
 
-// Commit is used to finalize the transaction and return a new tree.
+// Commit is used to finalize the transaction and return a new tree. If mutation
+// tracking is turned on then notifications will also be issued.
 func (t *Transaction) Commit() *Tree {
+	nt := t.CommitOnly()
+	t.Notify()
+	return nt
+}
+
+// CommitOnly is used to finalize the transaction and return a new tree, but
+// does not issue any notifications until Notify is called.
+func (t *Transaction) CommitOnly() *Tree {
 	nt := &Tree{t.root, t.size}
 	return nt
 }
 
+// Notify is used along with TrackMutate to trigger notifications. This must
+// only be done once a transaction is committed via CommitOnly.
+func (t *Transaction) Notify() {
+	for ch := range t.trackChannels {
+		close(ch)
+	}
+	t.trackChannels = nil
+	t.trackOrder = nil
+}
+
 // Insert is used to add or update a given key.
 func (t *Tree) Insert(k []byte, v interface{}) (*Tree, interface{}, bool) {
 	txn := t.Transaction()
@@ -171,6 +404,27 @@ func (t *Tree) Insert(k []byte, v interface{}) (*Tree, interface{}, bool) {
 	return txn.Commit(), old, ok
 }
 
+// Delete is used to delete a given key. Returns the new tree,
+// old value if any, and a bool indicating if the key was set.
+func (t *Tree) Delete(k []byte) (*Tree, interface{}, bool) {
+	txn := t.Transaction()
+	old, ok := txn.Delete(k)
+	return txn.Commit(), old, ok
+}
+
+// DeletePrefix is used to delete all nodes starting with a given prefix.
+// Returns the new tree, and a bool indicating if the prefix matched any nodes.
+func (t *Tree) DeletePrefix(k []byte) (*Tree, bool) {
+	txn := t.Transaction()
+	ok := txn.DeletePrefix(k)
+	return txn.Commit(), ok
+}
+
+// Len returns the number of keys in the tree.
+func (t *Tree) Len() int {
+	return t.size
+}
+
 // Root returns the root node of the tree which can be used for richer query operations.
 func (t *Tree) Root() *Node {
 	return t.root
@@ -181,6 +435,26 @@ func (t *Tree) Get(k []byte) (interface{}, bool) {
 	return t.root.Get(k)
 }
 
+// trackSubtree tracks the mutation channels of every node and leaf in the
+// subtree rooted at n.
+func (t *Transaction) trackSubtree(n *Node) {
+	t.trackChannel(n.mutateCh)
+	if n.isLeaf() {
+		t.trackChannel(n.leaf.mutateCh)
+	}
+	for _, e := range n.edges {
+		t.trackSubtree(e.node)
+	}
+}
+
+// concat two byte slices, returning a third new copy
+func concat(a, b []byte) []byte {
+	c := make([]byte, len(a)+len(b))
+	copy(c, a)
+	copy(c[len(a):], b)
+	return c
+}
+
 func longestPrefix(k1, k2 []byte) int {
 	max := len(k1)
 	if l := len(k2); l < max {
@@ -193,4 +467,4 @@ func longestPrefix(k1, k2 []byte) int {
 		}
 	}
 	return i
-}
\ No newline at end of file
+}
@@ -0,0 +1,173 @@
+package tree
+
+import "bytes"
+
+// ReverseIterator is used to iterate over a set of nodes in reverse in-order
+type ReverseIterator struct {
+	i *Iterator
+
+	// expandedParents stores the set of parent nodes whose relevant children
+	// have already been pushed onto the stack. Unlike forward iteration, all the
+	// children of a node must be visited before its own leaf since they are
+	// greater than it.
+	expandedParents map[*Node]struct{}
+}
+
+// NewReverseIterator returns a new ReverseIterator at a node
+func NewReverseIterator(n *Node) *ReverseIterator {
+	return &ReverseIterator{
+		i: &Iterator{node: n},
+	}
+}
+
+// SeekPrefixWatch is used to seek the iterator to a given prefix
+// and returns the watch channel of the finest granularity
+func (ri *ReverseIterator) SeekPrefixWatch(prefix []byte) (watch <-chan struct{}) {
+	return ri.i.SeekPrefixWatch(prefix)
+}
+
+// SeekPrefix is used to seek the iterator to a given prefix
+func (ri *ReverseIterator) SeekPrefix(prefix []byte) {
+	ri.i.SeekPrefixWatch(prefix)
+}
+
+// SeekReverseLowerBound is used to seek the iterator to the largest key that is
+// lower or equal to the given key. There is no watch variant as it's hard to
+// predict based on the radix structure which node(s) changes might affect the
+// result.
+func (ri *ReverseIterator) SeekReverseLowerBound(key []byte) {
+	// The stack is built as we go since only a subset of the edges of the
+	// nodes on the path to the lower bound are smaller than the key.
+	ri.i.stack = []edges{}
+	n := ri.i.node
+	ri.i.node = nil
+	search := key
+
+	if ri.expandedParents == nil {
+		ri.expandedParents = make(map[*Node]struct{})
+	}
+
+	found := func(n *Node) {
+		ri.i.stack = append(ri.i.stack, edges{edge{node: n}})
+		// Mark the node as expanded so Previous does not walk its children,
+		// which are all greater than the lower bound.
+		ri.expandedParents[n] = struct{}{}
+	}
+
+	for {
+		// Compare current prefix with the search key's same-length prefix
+		var prefixCmp int
+		if len(n.prefix) < len(search) {
+			prefixCmp = bytes.Compare(n.prefix, search[0:len(n.prefix)])
+		} else {
+			prefixCmp = bytes.Compare(n.prefix, search)
+		}
+
+		if prefixCmp < 0 {
+			// Everything in this subtree is smaller than the key, so the
+			// reverse lower bound is its largest leaf. Previous finds it by
+			// expanding the node as usual.
+			ri.i.stack = append(ri.i.stack, edges{edge{node: n}})
+			return
+		}
+
+		if prefixCmp > 0 {
+			// Everything in this subtree is greater than the key, the reverse
+			// lower bound (if any) is in the edges already on the stack
+			return
+		}
+
+		// The prefix is equal, so a leaf here is either an exact match or
+		// smaller than the key
+		if n.isLeaf() {
+			if bytes.Equal(n.leaf.key, key) {
+				found(n)
+				return
+			}
+
+			if len(n.edges) == 0 {
+				found(n)
+				return
+			}
+
+			// The leaf comes before any of the children pushed below, which
+			// are added by the search rather than by expanding the node.
+			ri.i.stack = append(ri.i.stack, edges{edge{node: n}})
+			ri.expandedParents[n] = struct{}{}
+		}
+
+		// Consume the search prefix. The prefix cannot be longer than the
+		// search here, as prefixCmp would have been greater than zero.
+		search = search[len(n.prefix):]
+
+		if len(search) == 0 {
+			// All the children are greater than the key
+			return
+		}
+
+		idx, lbNode := n.getLowerBoundEdge(search[0])
+
+		// Without a lower bound edge every edge comes before the key
+		if idx == -1 {
+			idx = len(n.edges)
+		}
+
+		// All the strictly lower edges of this node come before the key
+		if len(n.edges[:idx]) > 0 {
+			ri.i.stack = append(ri.i.stack, n.edges[:idx])
+		}
+
+		if lbNode == nil {
+			return
+		}
+
+		n = lbNode
+	}
+}
+
+// Previous returns the previous node in reverse order
+func (ri *ReverseIterator) Previous() ([]byte, interface{}, bool) {
+	if ri.i.stack == nil && ri.i.node != nil {
+		ri.i.stack = []edges{
+			{
+				edge{node: ri.i.node},
+			},
+		}
+	}
+
+	if ri.expandedParents == nil {
+		ri.expandedParents = make(map[*Node]struct{})
+	}
+
+	for len(ri.i.stack) > 0 {
+		n := len(ri.i.stack)
+		last := ri.i.stack[n-1]
+		m := len(last)
+		elem := last[m-1].node
+
+		_, alreadyExpanded := ri.expandedParents[elem]
+
+		// An internal node stays on the stack until all its children are
+		// visited, as its own leaf is smaller than all of them
+		if len(elem.edges) > 0 && !alreadyExpanded {
+			ri.expandedParents[elem] = struct{}{}
+			ri.i.stack = append(ri.i.stack, elem.edges)
+			continue
+		}
+
+		if m > 1 {
+			ri.i.stack[n-1] = last[:m-1]
+		} else {
+			ri.i.stack = ri.i.stack[:n-1]
+		}
+
+		if alreadyExpanded {
+			delete(ri.expandedParents, elem)
+		}
+
+		if elem.leaf != nil {
+			return elem.leaf.key, elem.leaf.val, true
+		}
+	}
+	return nil, nil, false
+}
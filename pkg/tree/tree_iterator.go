@@ -8,10 +8,12 @@ type Iterator struct {
 	stack []edges
 }
 
-// SeekPrefix is used to seek the iterator to a given prefix
-func (i *Iterator) SeekPrefix(prefix []byte) () {
+// SeekPrefixWatch is used to seek the iterator to a given prefix
+// and returns the watch channel of the finest granularity
+func (i *Iterator) SeekPrefixWatch(prefix []byte) (watch <-chan struct{}) {
 	i.stack = nil
 	n := i.node
+	watch = n.mutateCh
 	search := prefix
 	for {
 		if len(search) == 0 {
@@ -25,6 +27,9 @@ func (i *Iterator) SeekPrefix(prefix []byte) () {
 			return
 		}
 
+		// Update to the finest granularity as the search makes progress
+		watch = n.mutateCh
+
 		if bytes.HasPrefix(search, n.prefix) {
 			search = search[len(n.prefix):]
 
@@ -38,6 +43,106 @@ func (i *Iterator) SeekPrefix(prefix []byte) () {
 	}
 }
 
+// SeekPrefix is used to seek the iterator to a given prefix
+func (i *Iterator) SeekPrefix(prefix []byte) {
+	i.SeekPrefixWatch(prefix)
+}
+
+// SeekLowerBound is used to seek the iterator to the smallest key that is
+// greater or equal to the given key. There is no watch variant as it's hard to
+// predict based on the radix structure which node(s) changes might affect the
+// result.
+func (i *Iterator) SeekLowerBound(key []byte) {
+	// The stack is built as we go since only a subset of the edges of the
+	// nodes on the path to the lower bound are greater than the key.
+	i.stack = []edges{}
+	n := i.node
+	i.node = nil
+	search := key
+
+	found := func(n *Node) {
+		i.stack = append(i.stack, edges{edge{node: n}})
+	}
+
+	findMin := func(n *Node) {
+		n = i.recurseMin(n)
+		if n != nil {
+			found(n)
+		}
+	}
+
+	for {
+		// Compare current prefix with the search key's same-length prefix
+		var prefixCmp int
+		if len(n.prefix) < len(search) {
+			prefixCmp = bytes.Compare(n.prefix, search[0:len(n.prefix)])
+		} else {
+			prefixCmp = bytes.Compare(n.prefix, search)
+		}
+
+		if prefixCmp > 0 {
+			// Everything in this subtree is greater than the key, so the
+			// lower bound is its smallest leaf
+			findMin(n)
+			return
+		}
+
+		if prefixCmp < 0 {
+			// Everything in this subtree is smaller than the key, the lower
+			// bound (if any) is in the edges already on the stack
+			return
+		}
+
+		// Prefix is equal, if this is an exact match we're done
+		if n.leaf != nil && bytes.Equal(n.leaf.key, key) {
+			found(n)
+			return
+		}
+
+		// Consume the search prefix
+		if len(n.prefix) > len(search) {
+			search = []byte{}
+		} else {
+			search = search[len(n.prefix):]
+		}
+
+		if len(search) == 0 {
+			// The key is exhausted but this node is not an exact match, so its
+			// leaf and all children are strictly greater than the key
+			findMin(n)
+			return
+		}
+
+		idx, lbNode := n.getLowerBoundEdge(search[0])
+		if lbNode == nil {
+			return
+		}
+
+		// All the strictly higher edges of this node come after the lower bound
+		if idx+1 < len(n.edges) {
+			i.stack = append(i.stack, n.edges[idx+1:])
+		}
+
+		n = lbNode
+	}
+}
+
+// recurseMin walks down to the smallest leaf under n, pushing all the larger
+// edges passed on the way onto the stack.
+func (i *Iterator) recurseMin(n *Node) *Node {
+	if n.leaf != nil {
+		return n
+	}
+	nEdges := len(n.edges)
+	if nEdges > 1 {
+		i.stack = append(i.stack, n.edges[1:])
+	}
+	if nEdges > 0 {
+		return i.recurseMin(n.edges[0].node)
+	}
+	return nil
+}
+
 // Next returns the next node in order
 func (i *Iterator) Next() ([]byte, interface{}, bool) {
 	if i.stack == nil && i.node != nil {